@@ -2,9 +2,11 @@ package cmd
 
 import (
 	"fmt"
+	"time"
 	"verifier/pkg/ingest"
 	"verifier/pkg/query"
 	"verifier/pkg/trace"
+	"verifier/pkg/utils"
 
 	log "github.com/sirupsen/logrus"
 
@@ -15,7 +17,7 @@ var ingestCmd = &cobra.Command{
 	Use:   "ingest",
 	Short: "Ingest",
 	Run: func(cmd *cobra.Command, args []string) {
-		log.Fatal("Ingestion command should be used with esbulk / metrics.")
+		log.Fatal("Ingestion command should be used with esbulk / metrics / promremote / influx / telnet.")
 	},
 }
 
@@ -36,6 +38,12 @@ var esBulkCmd = &cobra.Command{
 		dataFile, _ := cmd.Flags().GetString("filePath")
 		indexName, _ := cmd.Flags().GetString("indexName")
 		bearerToken, _ := cmd.Flags().GetString("bearerToken")
+		timestampField, _ := cmd.Flags().GetString("timestampField")
+		timestampFormat, _ := cmd.Flags().GetString("timestampFormat")
+		timestampMode, _ := cmd.Flags().GetString("timestampMode")
+		incidentsFile, _ := cmd.Flags().GetString("incidents")
+		incidentManifest, _ := cmd.Flags().GetString("incidentManifest")
+		seed, _ := cmd.Flags().GetInt64("seed")
 
 		log.Infof("processCount : %+v\n", processCount)
 		log.Infof("dest : %+v\n", dest)
@@ -46,8 +54,29 @@ var esBulkCmd = &cobra.Command{
 		log.Infof("numIndices : %+v\n", numIndices)
 		log.Infof("bearerToken : %+v\n", bearerToken)
 		log.Infof("generatorType : %+v. Add timestamp: %+v\n", generatorType, ts)
+		log.Infof("seed : %+v\n", seed)
 
-		ingest.StartIngestion(ingest.ESBulk, generatorType, dataFile, totalEvents, continuous, batchSize, dest, indexPrefix, indexName, numIndices, processCount, ts, 0, bearerToken)
+		var tsRewriter *utils.TimestampRewriter
+		if timestampField != "" {
+			log.Infof("timestampField : %+v. Format: %+v. Mode: %+v\n", timestampField, timestampFormat, timestampMode)
+			var err error
+			tsRewriter, err = utils.InitTimestampRewriter(timestampField, timestampFormat, timestampMode)
+			if err != nil {
+				log.Fatalf("Invalid timestamp rewrite options: %+v", err)
+			}
+		}
+
+		var injector *utils.IncidentInjector
+		if incidentsFile != "" {
+			log.Infof("incidents : %+v. Manifest: %+v\n", incidentsFile, incidentManifest)
+			var err error
+			injector, err = utils.InitIncidentInjector(incidentsFile, incidentManifest)
+			if err != nil {
+				log.Fatalf("Invalid incidents file: %+v", err)
+			}
+		}
+
+		ingest.StartIngestion(ingest.ESBulk, generatorType, dataFile, totalEvents, continuous, batchSize, dest, indexPrefix, indexName, numIndices, processCount, ts, nil, bearerToken, seed, tsRewriter, injector)
 	},
 }
 
@@ -56,27 +85,113 @@ var metricsIngestCmd = &cobra.Command{
 	Use:   "metrics",
 	Short: "ingest metrics to /api/put in OTSDB format",
 	Run: func(cmd *cobra.Command, args []string) {
-		processCount, _ := cmd.Flags().GetInt("processCount")
+		ingestMetrics(cmd, ingest.OpenTSDB)
+	},
+}
+
+// promRemoteWriteCmd represents the prometheus remote write ingestion
+var promRemoteWriteCmd = &cobra.Command{
+	Use:   "promremote",
+	Short: "ingest metrics to /api/v1/write using prometheus remote write",
+	Run: func(cmd *cobra.Command, args []string) {
+		ingestMetrics(cmd, ingest.PrometheusRemoteWrite)
+	},
+}
+
+// influxIngestCmd represents the influx line protocol ingestion
+var influxIngestCmd = &cobra.Command{
+	Use:   "influx",
+	Short: "ingest metrics to /api/v2/write or /write using influx line protocol",
+	Run: func(cmd *cobra.Command, args []string) {
+		api, _ := cmd.Flags().GetString("influxApi")
+		bucket, _ := cmd.Flags().GetString("bucket")
+		org, _ := cmd.Flags().GetString("org")
+		precision, _ := cmd.Flags().GetString("precision")
 		dest, _ := cmd.Flags().GetString("dest")
-		totalEvents, _ := cmd.Flags().GetInt("totalEvents")
-		continuous, _ := cmd.Flags().GetBool("continuous")
-		batchSize, _ := cmd.Flags().GetInt("batchSize")
-		nMetrics, _ := cmd.Flags().GetInt("metrics")
-		bearerToken, _ := cmd.Flags().GetString("bearerToken")
 
-		log.Infof("processCount : %+v\n", processCount)
-		log.Infof("dest : %+v\n", dest)
-		log.Infof("totalEvents : %+v. Continuous: %+v\n", totalEvents, continuous)
-		log.Infof("batchSize : %+v. Num metrics: %+v\n", batchSize, nMetrics)
-		log.Infof("bearerToken : %+v\n", bearerToken)
-		ingest.StartIngestion(ingest.OpenTSDB, "", "", totalEvents, continuous, batchSize, dest, "", "", 0, processCount, false, nMetrics, bearerToken)
+		log.Infof("influxApi : %+v. Bucket: %+v. Org: %+v. Precision: %+v\n", api, bucket, org, precision)
+		writeURL, err := ingest.InfluxWriteURL(dest, api, bucket, org, precision)
+		if err != nil {
+			log.Fatalf("Invalid influx options: %+v", err)
+		}
+		err = cmd.Flags().Set("dest", writeURL)
+		if err != nil {
+			log.Fatalf("Failed to set write url: %+v", err)
+		}
+		ingestMetrics(cmd, ingest.Influx)
 	},
 }
 
+// telnetIngestCmd represents the OTSDB telnet style ingestion
+var telnetIngestCmd = &cobra.Command{
+	Use:   "telnet",
+	Short: "ingest metrics with OTSDB put lines over tcp. -d is host:port",
+	Run: func(cmd *cobra.Command, args []string) {
+		ingestMetrics(cmd, ingest.OpenTSDBTelnet)
+	},
+}
+
+// sends the metrics generator's output with the protocol of iType
+func ingestMetrics(cmd *cobra.Command, iType ingest.IngestType) {
+	processCount, _ := cmd.Flags().GetInt("processCount")
+	dest, _ := cmd.Flags().GetString("dest")
+	totalEvents, _ := cmd.Flags().GetInt("totalEvents")
+	continuous, _ := cmd.Flags().GetBool("continuous")
+	batchSize, _ := cmd.Flags().GetInt("batchSize")
+	nMetrics, _ := cmd.Flags().GetInt("metrics")
+	bearerToken, _ := cmd.Flags().GetString("bearerToken")
+	seed, _ := cmd.Flags().GetInt64("seed")
+	tagSpec, _ := cmd.Flags().GetString("tags")
+	cycleSeries, _ := cmd.Flags().GetBool("cycleSeries")
+	valueModel, _ := cmd.Flags().GetString("valueModel")
+	backfill, _ := cmd.Flags().GetDuration("backfill")
+	interval, _ := cmd.Flags().GetDuration("interval")
+	churnInterval, _ := cmd.Flags().GetDuration("churnInterval")
+	churnFraction, _ := cmd.Flags().GetFloat64("churnFraction")
+	churnLifetime, _ := cmd.Flags().GetString("churnLifetime")
+	churnTag, _ := cmd.Flags().GetString("churnTag")
+	seriesCounter, _ := cmd.Flags().GetString("seriesCounter")
+	hllPrecision, _ := cmd.Flags().GetInt("hllPrecision")
+
+	log.Infof("processCount : %+v\n", processCount)
+	log.Infof("dest : %+v\n", dest)
+	log.Infof("totalEvents : %+v. Continuous: %+v\n", totalEvents, continuous)
+	log.Infof("batchSize : %+v. Num metrics: %+v\n", batchSize, nMetrics)
+	log.Infof("bearerToken : %+v\n", bearerToken)
+	log.Infof("seed : %+v\n", seed)
+	log.Infof("tags : %+v. Cycle series: %+v\n", tagSpec, cycleSeries)
+	log.Infof("valueModel : %+v\n", valueModel)
+	log.Infof("backfill : %+v. Interval: %+v\n", backfill, interval)
+	log.Infof("churnInterval : %+v. Churn fraction: %+v. Churn lifetime: %+v. Churn tag: %+v\n", churnInterval, churnFraction, churnLifetime, churnTag)
+	log.Infof("seriesCounter : %+v. HLL precision: %+v\n", seriesCounter, hllPrecision)
+
+	tags, err := utils.ParseTagSchema(tagSpec)
+	if err != nil {
+		log.Fatalf("Failed to parse tags: %+v", err)
+	}
+	metricsCfg := &utils.MetricsConfig{NumMetrics: nMetrics, Tags: tags, CycleSeries: cycleSeries, ValueModel: valueModel,
+		Backfill: backfill, Interval: interval,
+		ChurnInterval: churnInterval, ChurnFraction: churnFraction, ChurnLifetime: churnLifetime, ChurnTag: churnTag,
+		SeriesCounter: seriesCounter, HLLPrecision: hllPrecision}
+	err = metricsCfg.Init()
+	if err != nil {
+		log.Fatalf("Invalid metrics config: %+v", err)
+	}
+	if numSeries, ok := metricsCfg.NumSeries(); ok {
+		log.Infof("Number of unique series: %+v", numSeries)
+	}
+	if backfill > 0 {
+		// the generators stop once the whole range has been sent
+		log.Infof("Backfilling %+v points. Ignoring -t and -c", metricsCfg.NumBackfillPoints())
+		continuous = true
+	}
+	ingest.StartIngestion(iType, "", "", totalEvents, continuous, batchSize, dest, "", "", 0, processCount, false, metricsCfg, bearerToken, seed, nil, nil)
+}
+
 var esQueryCmd = &cobra.Command{
 	Use:   "esbulk",
 	Short: "send esbulk queries to SigScalr",
-	Run: func(cmd *cobra.Command, args []string) {
+	Run: cmdWrap.Run(func(cmd *cobra.Command, args []string) error {
 		dest, _ := cmd.Flags().GetString("dest")
 		numIterations, _ := cmd.Flags().GetInt("numIterations")
 		verbose, _ := cmd.Flags().GetBool("verbose")
@@ -85,6 +200,15 @@ var esQueryCmd = &cobra.Command{
 		filepath, _ := cmd.Flags().GetString("filePath")
 		randomQueries, _ := cmd.Flags().GetBool("randomQueries")
 		bearerToken, _ := cmd.Flags().GetString("bearerToken")
+		aggs, _ := cmd.Flags().GetBool("aggs")
+		pagination, _ := cmd.Flags().GetBool("pagination")
+		validateAggs, _ := cmd.Flags().GetBool("validateAggs")
+		validatePages, _ := cmd.Flags().GetBool("validatePages")
+		indices, _ := cmd.Flags().GetString("indices")
+		msearchSize, _ := cmd.Flags().GetInt("msearch")
+		randomSchema, _ := cmd.Flags().GetString("randomSchema")
+		seed, _ := cmd.Flags().GetInt64("seed")
+		wsDest, _ := cmd.Flags().GetString("wsDest")
 
 		log.Infof("dest : %+v\n", dest)
 		log.Infof("numIterations : %+v\n", numIterations)
@@ -94,12 +218,49 @@ var esQueryCmd = &cobra.Command{
 		log.Infof("filePath : %+v\n", filepath)
 		log.Infof("randomQueries: %+v\n", randomQueries)
 		log.Infof("bearerToken : %+v\n", bearerToken)
+		log.Infof("aggs : %+v\n", aggs)
+		log.Infof("pagination : %+v\n", pagination)
+		log.Infof("validateAggs : %+v\n", validateAggs)
+		log.Infof("validatePages : %+v\n", validatePages)
+		log.Infof("indices : %+v\n", indices)
+		log.Infof("msearch : %+v\n", msearchSize)
+		log.Infof("randomSchema : %+v\n", randomSchema)
+		log.Infof("seed : %+v\n", seed)
+		log.Infof("wsDest : %+v\n", wsDest)
 		if filepath != "" {
-			query.RunQueryFromFile(dest, numIterations, indexPrefix, continuous, verbose, filepath, bearerToken)
-		} else {
-			query.StartQuery(dest, numIterations, indexPrefix, continuous, verbose, randomQueries, bearerToken)
+			query.RunQueryFromFile(dest, wsDest, numIterations, indexPrefix, continuous, verbose, filepath, bearerToken)
+			return nil
 		}
-	},
+		if validateAggs && !aggs {
+			log.Errorf("--validateAggs requires --aggs")
+			return fmt.Errorf("--validateAggs requires --aggs")
+		}
+		if validatePages && !pagination {
+			log.Errorf("--validatePages requires --pagination")
+			return fmt.Errorf("--validatePages requires --pagination")
+		}
+		var schema *query.ESSchema
+		if randomSchema != "" {
+			if !randomQueries {
+				log.Errorf("--randomSchema requires --randomQueries")
+				return fmt.Errorf("--randomSchema requires --randomQueries")
+			}
+			var err error
+			schema, err = query.GetESSchema(randomSchema, seed, dest, indexPrefix, indices, bearerToken)
+			if err != nil {
+				log.Errorf("Failed to get the schema of random queries: %+v", err)
+				return err
+			}
+		}
+		res := query.StartQuery(dest, numIterations, indexPrefix, indices, continuous, verbose, randomQueries, bearerToken, aggs, pagination, validateAggs, validatePages, msearchSize, schema)
+		for k, v := range res {
+			if !v {
+				log.Errorf("esbulk query has invalid results for query type: %s", k)
+				return fmt.Errorf("esbulk query has invalid results for query type: %s", k)
+			}
+		}
+		return nil
+	}),
 }
 
 var cmdWrap wrapper
@@ -125,17 +286,24 @@ var metricsQueryCmd = &cobra.Command{
 		verbose, _ := cmd.Flags().GetBool("verbose")
 		continuous, _ := cmd.Flags().GetBool("continuous")
 		validateMetricsOutput, _ := cmd.Flags().GetBool("validateMetricsOutput")
+		timeout, _ := cmd.Flags().GetDuration("timeout")
 
 		log.Infof("dest : %+v\n", dest)
 		log.Infof("numIterations : %+v\n", numIterations)
 		log.Infof("verbose : %+v\n", verbose)
 		log.Infof("continuous : %+v\n", continuous)
 		log.Infof("validateMetricsOutput : %+v\n", validateMetricsOutput)
-		resTS := query.StartMetricsQuery(dest, numIterations, continuous, verbose, validateMetricsOutput)
+		log.Infof("timeout : %+v\n", timeout)
+
+		validation, err := getMetricsValidation(cmd)
+		if err != nil {
+			return err
+		}
+		resTS := query.StartMetricsQuery(dest, numIterations, continuous, verbose, validateMetricsOutput, timeout, validation)
 		for k, v := range resTS {
 			if !v {
-				log.Errorf("metrics query has no results for query type: %s", k)
-				return fmt.Errorf("metrics query has no results for query type: %s", k)
+				log.Errorf("metrics query failed or has no results or wrong values for query type: %s", k)
+				return fmt.Errorf("metrics query failed or has no results or wrong values for query type: %s", k)
 			}
 		}
 
@@ -143,11 +311,99 @@ var metricsQueryCmd = &cobra.Command{
 	}),
 }
 
+var promqlQueryCmd = &cobra.Command{
+	Use:   "promql",
+	Short: "send promql queries to /api/v1/query and /api/v1/query_range",
+	Run: cmdWrap.Run(func(cmd *cobra.Command, args []string) error {
+		dest, _ := cmd.Flags().GetString("dest")
+		numIterations, _ := cmd.Flags().GetInt("numIterations")
+		verbose, _ := cmd.Flags().GetBool("verbose")
+		continuous, _ := cmd.Flags().GetBool("continuous")
+		validateMetricsOutput, _ := cmd.Flags().GetBool("validateMetricsOutput")
+		timeout, _ := cmd.Flags().GetDuration("timeout")
+
+		log.Infof("dest : %+v\n", dest)
+		log.Infof("numIterations : %+v\n", numIterations)
+		log.Infof("verbose : %+v\n", verbose)
+		log.Infof("continuous : %+v\n", continuous)
+		log.Infof("validateMetricsOutput : %+v\n", validateMetricsOutput)
+		log.Infof("timeout : %+v\n", timeout)
+
+		validation, err := getMetricsValidation(cmd)
+		if err != nil {
+			return err
+		}
+		resTS := query.StartPromQLQuery(dest, numIterations, continuous, verbose, validateMetricsOutput, timeout, validation)
+		for k, v := range resTS {
+			if !v {
+				log.Errorf("promql query failed or has invalid results for query type: %s", k)
+				return fmt.Errorf("promql query failed or has invalid results for query type: %s", k)
+			}
+		}
+
+		return nil
+	}),
+}
+
+var wsQueryCmd = &cobra.Command{
+	Use:   "languages",
+	Short: "send equivalent queries in every query language over the websocket api and compare their latencies",
+	Run: cmdWrap.Run(func(cmd *cobra.Command, args []string) error {
+		dest, _ := cmd.Flags().GetString("dest")
+		numIterations, _ := cmd.Flags().GetInt("numIterations")
+		verbose, _ := cmd.Flags().GetBool("verbose")
+		continuous, _ := cmd.Flags().GetBool("continuous")
+		indexPrefix, _ := cmd.Flags().GetString("indexPrefix")
+		languages, _ := cmd.Flags().GetStringSlice("queryLanguages")
+		validateRecords, _ := cmd.Flags().GetBool("validateRecords")
+
+		log.Infof("dest : %+v\n", dest)
+		log.Infof("numIterations : %+v\n", numIterations)
+		log.Infof("indexPrefix : %+v\n", indexPrefix)
+		log.Infof("verbose : %+v\n", verbose)
+		log.Infof("continuous : %+v\n", continuous)
+		log.Infof("queryLanguages : %+v\n", languages)
+		log.Infof("validateRecords : %+v\n", validateRecords)
+
+		res := query.StartWebsocketQuery(dest, numIterations, indexPrefix, continuous, verbose, languages, validateRecords)
+		for k, v := range res {
+			if !v {
+				log.Errorf("websocket query has invalid results for query type: %s", k)
+				return fmt.Errorf("websocket query has invalid results for query type: %s", k)
+			}
+		}
+		return nil
+	}),
+}
+
+// returns the series that metrics queries are validated against, or nil if --tags is not set
+func getMetricsValidation(cmd *cobra.Command) (*query.MetricsValidation, error) {
+	tagSpec, _ := cmd.Flags().GetString("tags")
+	if tagSpec == "" {
+		return nil, nil
+	}
+	numMetrics, _ := cmd.Flags().GetInt("metrics")
+	valueModel, _ := cmd.Flags().GetString("valueModel")
+	interval, _ := cmd.Flags().GetDuration("interval")
+	tolerance, _ := cmd.Flags().GetFloat64("valueTolerance")
+	log.Infof("tags : %+v. Metrics: %+v. ValueModel: %+v. Interval: %+v. Tolerance: %+v\n", tagSpec, numMetrics, valueModel, interval, tolerance)
+	tags, err := utils.ParseTagSchema(tagSpec)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &utils.MetricsConfig{NumMetrics: numMetrics, Tags: tags, ValueModel: valueModel}
+	err = cfg.Init()
+	if err != nil {
+		return nil, err
+	}
+	return &query.MetricsValidation{Cfg: cfg, Interval: interval, Tolerance: tolerance}, nil
+}
+
 var queryCmd = &cobra.Command{
 	Use:   "query",
-	Short: "send queries to SigScalr",
+	Short: "send esbulk, otsdb, promql or languages queries to SigScalr",
 	Run: func(cmd *cobra.Command, args []string) {
-		log.Fatal("Query command should be used with esbulk / metrics.")
+		log.Fatal("Query command should be used with esbulk / otsdb / promql / languages.")
 	},
 }
 
@@ -166,6 +422,31 @@ var traceCmd = &cobra.Command{
 	},
 }
 
+// flags of the metrics generator, shared by all metrics ingestion commands
+func addMetricsFlags(cmd *cobra.Command) {
+	cmd.PersistentFlags().IntP("metrics", "m", 1_000, "Number of different metric names to send")
+	cmd.PersistentFlags().String("tags", "", "tag keys and the number of values of each, e.g. host=10,pod=50,region=3. If empty, random tags are sent")
+	cmd.PersistentFlags().Bool("cycleSeries", false, "If set, sends every series in order instead of random series. Requires --tags")
+	cmd.PersistentFlags().Duration("backfill", 0, "If set, sends every series once per --interval from this long ago until now, e.g. 168h. Requires --tags")
+	cmd.PersistentFlags().Duration("interval", 15*time.Second, "scrape interval used when backfilling")
+	cmd.PersistentFlags().Duration("churnInterval", 0, "If set, replaces --churnFraction of the series every interval, e.g. 10m. Requires --tags")
+	cmd.PersistentFlags().Float64("churnFraction", 0.1, "fraction of series replaced every --churnInterval")
+	cmd.PersistentFlags().String("churnLifetime", utils.ChurnExponential, "lifetime distribution of churned series. Options=[exponential,fixed]")
+	cmd.PersistentFlags().String("churnTag", "", "tag that gets a new value when a series is replaced. Defaults to the last key of --tags")
+	cmd.PersistentFlags().String("seriesCounter", utils.CounterExact, "how to count unique series sent. Options=[exact,hll]")
+	cmd.PersistentFlags().Int("hllPrecision", 14, "number of bits for HLL registers when --seriesCounter=hll. Standard error is about 1.04/sqrt(2^precision)")
+	cmd.PersistentFlags().String("valueModel", utils.ValueMixed, "model for the values of each series. Options=[counter,gauge,sine,step,histogram,mixed]")
+}
+
+// flags describing backfilled metrics, shared by the metrics query commands to validate returned values
+func addMetricsValidationFlags(cmd *cobra.Command) {
+	cmd.Flags().String("tags", "", "tag schema the metrics were backfilled with, e.g. host=10,pod=50. If set with -y, returned values are checked against the generated series")
+	cmd.Flags().IntP("metrics", "m", 1_000, "number of metric names the metrics were backfilled with")
+	cmd.Flags().String("valueModel", utils.ValueMixed, "value model the metrics were backfilled with. Options=[counter,gauge,sine,step,histogram,mixed]")
+	cmd.Flags().Duration("interval", 15*time.Second, "interval the metrics were backfilled with")
+	cmd.Flags().Float64("valueTolerance", 0.001, "max difference between a returned and an expected value, relative to the expected value")
+}
+
 func init() {
 	rootCmd.PersistentFlags().StringP("dest", "d", "", "Server URL.")
 	rootCmd.PersistentFlags().StringP("indexPrefix", "i", "ind", "index prefix")
@@ -176,26 +457,60 @@ func init() {
 	ingestCmd.PersistentFlags().IntP("totalEvents", "t", 1000000, "Total number of events to send")
 	ingestCmd.PersistentFlags().BoolP("continuous", "c", false, "Continous ingestion will ingore -t and will constantly send events as fast as possible")
 	ingestCmd.PersistentFlags().IntP("batchSize", "b", 100, "Batch size")
+	ingestCmd.PersistentFlags().Int64("seed", 0, "Seed for generated data. The same seed and processCount always generate the same data. If 0, a fixed default seed is used")
 
 	esBulkCmd.Flags().BoolP("timestamp", "s", false, "Add timestamp in payload")
 	esBulkCmd.PersistentFlags().IntP("numIndices", "n", 1, "number of indices to ingest to")
-	esBulkCmd.PersistentFlags().StringP("generator", "g", "dynamic-user", "type of generator to use. Options=[static,dynamic-user,file]. If file is selected, -x/--filePath must be specified")
-	esBulkCmd.PersistentFlags().StringP("filePath", "x", "", "path to json file to use as logs")
+	esBulkCmd.PersistentFlags().StringP("generator", "g", "dynamic-user", "type of generator to use. Options=[static,dynamic-user,file,csv,tsv,logfmt,parquet,benchmark,k8s]. If a file based generator is selected, -x/--filePath must be specified")
+	esBulkCmd.PersistentFlags().StringP("filePath", "x", "", "path to the file to use as logs. Can be a directory or a glob. gzip and zstd files are supported")
+	esBulkCmd.PersistentFlags().String("timestampField", "", "field to rewrite the timestamp of when replaying files. If empty, timestamps are sent as is")
+	esBulkCmd.PersistentFlags().String("timestampFormat", "epoch_millis", "format of the timestamp field. Options=[epoch_seconds,epoch_millis,epoch_nanos,rfc3339] or a go time layout")
+	esBulkCmd.PersistentFlags().String("timestampMode", "shift", "shift: shift all timestamps so the latest one is now and keep their spacing. The files are read once more before sending to find the latest one. now: set each timestamp to the time it is sent")
+	esBulkCmd.PersistentFlags().String("incidents", "", "path to a json file of incidents to inject into the dynamic-user, benchmark or k8s generators")
+	esBulkCmd.PersistentFlags().String("incidentManifest", "incidents_manifest.json", "path to write the manifest of injected incidents to")
 
-	metricsIngestCmd.PersistentFlags().IntP("metrics", "m", 1_000, "Number of different metric names to send")
+	addMetricsFlags(metricsIngestCmd)
+	addMetricsFlags(promRemoteWriteCmd)
+	addMetricsFlags(influxIngestCmd)
+	addMetricsFlags(telnetIngestCmd)
+	influxIngestCmd.Flags().String("influxApi", ingest.InfluxV2, "write api to use. v1 posts to /write and v2 to /api/v2/write. Options=[v1,v2]")
+	influxIngestCmd.Flags().String("bucket", "test", "bucket to write to. Used as the database for the v1 api")
+	influxIngestCmd.Flags().String("org", "", "organization to write to. Only used by the v2 api")
+	influxIngestCmd.Flags().String("precision", "ns", "precision of the timestamps. Options=[ns,ms,s]")
 
 	queryCmd.PersistentFlags().IntP("numIterations", "n", 10, "number of times to run entire query suite")
 	queryCmd.PersistentFlags().BoolP("verbose", "v", false, "Verbose querying will output raw docs returned by queries")
 	queryCmd.PersistentFlags().BoolP("continuous", "c", false, "Continuous querying will ignore -c and -v and will continuously send queries to the destination")
-	queryCmd.PersistentFlags().BoolP("validateMetricsOutput", "y", false, "check if metric querries return any results")
+	queryCmd.PersistentFlags().BoolP("validateMetricsOutput", "y", false, "validate the responses of otsdb and promql queries. With --tags, values are also compared with the ingested series")
 	queryCmd.PersistentFlags().StringP("filePath", "f", "", "filepath to csv file to use to run queries from")
 	queryCmd.PersistentFlags().BoolP("randomQueries", "", false, "generate random queries")
 
+	esQueryCmd.Flags().Bool("aggs", false, "also send the aggregation queries each iteration")
+	esQueryCmd.Flags().Bool("pagination", false, "also send the pagination queries each iteration")
+	esQueryCmd.Flags().Bool("validateAggs", false, "check that the buckets of aggregation queries are well formed")
+	esQueryCmd.Flags().Bool("validatePages", false, "check that pagination queries return sorted documents without repeats")
+	esQueryCmd.Flags().String("indices", "", "comma separated list of indices to search instead of {indexPrefix}*")
+	esQueryCmd.Flags().Int("msearch", 0, "if > 0, also send this many filter queries in one _msearch batch each iteration")
+	esQueryCmd.Flags().String("randomSchema", "", "fields of random queries. Either the generator the data was ingested with or mapping to read them from the server. Options=[dynamic-user,benchmark,static,k8s,mapping]")
+	esQueryCmd.Flags().String("wsDest", "http://localhost:5122", "server whose websocket api, at {wsDest}/api/search/ws, runs the queries of -f/--filePath")
+	esQueryCmd.Flags().Int64("seed", 0, "seed the data was ingested with, used to sample values of the random schema generator. If 0, the default seed of ingestion is used")
+	addMetricsValidationFlags(metricsQueryCmd)
+	metricsQueryCmd.Flags().Duration("timeout", 30*time.Second, "timeout of each query. Failed queries are counted as errors")
+	addMetricsValidationFlags(promqlQueryCmd)
+	promqlQueryCmd.Flags().Duration("timeout", 30*time.Second, "timeout of each query. Failed queries are counted as errors")
+	wsQueryCmd.Flags().Bool("validateRecords", false, "check that the returned records satisfy the filter of each query and are sorted by timestamp")
+	wsQueryCmd.Flags().StringSlice("queryLanguages", query.AllQueryLanguages, "comma separated query languages to compare. Options=[Pipe QL,Splunk QL,Log QL,SQL]")
+
 	queryCmd.AddCommand(esQueryCmd)
 	queryCmd.AddCommand(metricsQueryCmd)
+	queryCmd.AddCommand(promqlQueryCmd)
+	queryCmd.AddCommand(wsQueryCmd)
 
 	ingestCmd.AddCommand(esBulkCmd)
 	ingestCmd.AddCommand(metricsIngestCmd)
+	ingestCmd.AddCommand(promRemoteWriteCmd)
+	ingestCmd.AddCommand(influxIngestCmd)
+	ingestCmd.AddCommand(telnetIngestCmd)
 	traceCmd.PersistentFlags().StringP("filePrefix", "f", "", "Name of file to output to")
 	traceCmd.PersistentFlags().IntP("totalEvents", "t", 1000000, "Total number of traces to generate")
 	traceCmd.Flags().IntP("maxSpans", "s", 100, "max number of spans in a single trace")
@@ -5,9 +5,9 @@ import (
 	"encoding/json"
 	"flag"
 	"os"
-	"strconv"
 	"strings"
 	"time"
+	"verifier/pkg/utils"
 
 	log "github.com/sirupsen/logrus"
 )
@@ -48,39 +48,7 @@ func convertTSVToJson(input string, output string) error {
 			log.Infof("Still converting file. Total time %+v. Number of lines %+v", time.Since(sTime), count)
 		}
 		rawValues := strings.Split(scanner.Text(), "\t")
-		for idx, column := range columns {
-			if idx >= len(rawValues) {
-				jsonValue[column] = nil
-			} else {
-				value := rawValues[idx]
-
-				// Try converting to a number.
-				intVal, err := strconv.ParseInt(value, 10, 64)
-				if err == nil {
-					jsonValue[column] = intVal
-					continue
-				}
-
-				floatVal, err := strconv.ParseFloat(value, 64)
-				if err == nil {
-					jsonValue[column] = floatVal
-					continue
-				}
-
-				// Try converting to a boolean.
-				if value == "false" {
-					jsonValue[column] = false
-					continue
-				}
-				if value == "true" {
-					jsonValue[column] = true
-					continue
-				}
-
-				// Keep this as a string.
-				jsonValue[column] = value
-			}
-		}
+		utils.RowToMap(columns, rawValues, jsonValue)
 		rawVal, err := json.Marshal(jsonValue)
 		if err != nil {
 			log.Errorf("Failed to marshal json! %v", err)
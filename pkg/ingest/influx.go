@@ -0,0 +1,120 @@
+package ingest
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"verifier/pkg/utils"
+)
+
+// Influx write APIs
+const (
+	InfluxV1 = "v1"
+	InfluxV2 = "v2"
+)
+
+var influxPrecisions = map[string]time.Duration{
+	"ns": time.Nanosecond,
+	"ms": time.Millisecond,
+	"s":  time.Second,
+}
+
+var influxMeasurementEscaper = strings.NewReplacer(",", "\\,", " ", "\\ ")
+var influxTagEscaper = strings.NewReplacer(",", "\\,", "=", "\\=", " ", "\\ ")
+
+// InfluxWriteURL returns the write endpoint of dest for api. bucket is the database for InfluxV1.
+// precision is one of ns, ms or s and is also used for the timestamps in the body
+func InfluxWriteURL(dest, api, bucket, org, precision string) (string, error) {
+	if _, ok := influxPrecisions[precision]; !ok {
+		return "", fmt.Errorf("unsupported precision %s. Options=[ns,ms,s]", precision)
+	}
+	params := url.Values{}
+	params.Set("precision", precision)
+	switch api {
+	case InfluxV1:
+		params.Set("db", bucket)
+		return dest + "/write?" + params.Encode(), nil
+	case InfluxV2:
+		params.Set("bucket", bucket)
+		if org != "" {
+			params.Set("org", org)
+		}
+		return dest + "/api/v2/write?" + params.Encode(), nil
+	default:
+		return "", fmt.Errorf("unsupported influx api %s. Options=[%s,%s]", api, InfluxV1, InfluxV2)
+	}
+}
+
+// returns the precision query parameter of an url created by InfluxWriteURL
+func influxPrecision(writeURL string) (time.Duration, error) {
+	u, err := url.Parse(writeURL)
+	if err != nil {
+		return 0, err
+	}
+	precision, ok := influxPrecisions[u.Query().Get("precision")]
+	if !ok {
+		return 0, fmt.Errorf("no supported precision in %s", writeURL)
+	}
+	return precision, nil
+}
+
+// generateInfluxBody renders recs points as line protocol with a single value field
+func generateInfluxBody(recs int, rdr utils.Generator, precision time.Duration) ([]byte, int, error) {
+	var sb strings.Builder
+	sent := 0
+	for ; sent < recs; sent++ {
+		m, err := rdr.GetRawLog()
+		if err == io.EOF {
+			if sent == 0 {
+				return nil, 0, err
+			}
+			return []byte(sb.String()), sent, err
+		} else if err != nil {
+			return nil, 0, err
+		}
+		err = appendInfluxLine(&sb, m, precision)
+		if err != nil {
+			return nil, 0, err
+		}
+	}
+	return []byte(sb.String()), sent, nil
+}
+
+// measurement,tag=v,... value=<value> <timestamp>. Tags are sorted by key, as recommended by influx
+func appendInfluxLine(sb *strings.Builder, m map[string]interface{}, precision time.Duration) error {
+	mName, ok := m["metric"].(string)
+	if !ok {
+		return fmt.Errorf("unsupported metric name %+v", m["metric"])
+	}
+	ts, ok := m["timestamp"].(int64)
+	if !ok {
+		return fmt.Errorf("unsupported timestamp %+v", m["timestamp"])
+	}
+	value, ok := m["value"].(float64)
+	if !ok {
+		return fmt.Errorf("unsupported value %+v", m["value"])
+	}
+	sb.WriteString(influxMeasurementEscaper.Replace(mName))
+	tags, _ := m["tags"].(map[string]interface{})
+	keys := make([]string, 0, len(tags))
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		sb.WriteString(",")
+		sb.WriteString(influxTagEscaper.Replace(k))
+		sb.WriteString("=")
+		sb.WriteString(influxTagEscaper.Replace(fmt.Sprintf("%v", tags[k])))
+	}
+	sb.WriteString(" value=")
+	sb.WriteString(strconv.FormatFloat(value, 'g', -1, 64))
+	sb.WriteString(" ")
+	sb.WriteString(strconv.FormatInt(ts*int64(time.Second/precision), 10))
+	sb.WriteString("\n")
+	return nil
+}
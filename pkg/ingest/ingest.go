@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"strings"
@@ -15,7 +16,6 @@ import (
 	"github.com/dustin/go-humanize"
 	log "github.com/sirupsen/logrus"
 	"github.com/valyala/bytebufferpool"
-	"github.com/valyala/fastrand"
 )
 
 type IngestType int
@@ -25,6 +25,9 @@ const (
 	_ IngestType = iota
 	ESBulk
 	OpenTSDB
+	PrometheusRemoteWrite
+	Influx
+	OpenTSDBTelnet
 )
 
 func (q IngestType) String() string {
@@ -33,11 +36,22 @@ func (q IngestType) String() string {
 		return "ES Bulk"
 	case OpenTSDB:
 		return "OTSDB"
+	case PrometheusRemoteWrite:
+		return "Prometheus Remote Write"
+	case Influx:
+		return "Influx"
+	case OpenTSDBTelnet:
+		return "OTSDB Telnet"
 	default:
 		return "UNKNOWN"
 	}
 }
 
+// metrics ingest types send the output of the metrics generator
+func (q IngestType) isMetrics() bool {
+	return q == OpenTSDB || q == PrometheusRemoteWrite || q == Influx || q == OpenTSDBTelnet
+}
+
 const PRINT_FREQ = 100_000
 const RETRY_COUNT = 10
 
@@ -54,6 +68,11 @@ func sendRequest(iType IngestType, client *http.Client, lines []byte, url string
 		requestStr = url + "/_bulk"
 	case OpenTSDB:
 		requestStr = url + "/api/put"
+	case PrometheusRemoteWrite:
+		requestStr = url + "/api/v1/write"
+	case Influx:
+		// created by InfluxWriteURL
+		requestStr = url
 
 	default:
 		log.Fatalf("unknown ingest type %+v", iType)
@@ -64,7 +83,15 @@ func sendRequest(iType IngestType, client *http.Client, lines []byte, url string
 	if bearerToken != "" {
 		req.Header.Add("Authorization", bearerToken)
 	}
-	req.Header.Set("Content-Type", "application/json")
+	if iType == PrometheusRemoteWrite {
+		req.Header.Set("Content-Type", "application/x-protobuf")
+		req.Header.Set("Content-Encoding", "snappy")
+		req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+	} else if iType == Influx {
+		req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	} else {
+		req.Header.Set("Content-Type", "application/json")
+	}
 
 	if err != nil {
 		log.Errorf("sendRequest: http.NewRequest ERROR: %v", err)
@@ -84,18 +111,27 @@ func sendRequest(iType IngestType, client *http.Client, lines []byte, url string
 	return nil
 }
 
+// returns the body and the number of records in it. If the reader runs out of records, returns the records read so
+// far and io.EOF
 func generateBody(iType IngestType, recs int, i int, rdr utils.Generator,
-	actLines []string, bb *bytebufferpool.ByteBuffer) ([]byte, error) {
+	actLines []string, bb *bytebufferpool.ByteBuffer, influxPrecision time.Duration) ([]byte, int, error) {
 	switch iType {
 	case ESBulk:
 		actionLine := actLines[i%len(actLines)]
-		return generateESBody(recs, actionLine, rdr, bb)
+		payload, err := generateESBody(recs, actionLine, rdr, bb)
+		return payload, recs, err
 	case OpenTSDB:
 		return generateOpenTSDBBody(recs, rdr)
+	case PrometheusRemoteWrite:
+		return generatePromRemoteWriteBody(recs, rdr)
+	case Influx:
+		return generateInfluxBody(recs, rdr, influxPrecision)
+	case OpenTSDBTelnet:
+		return generateOpenTSDBTelnetBody(recs, rdr)
 	default:
 		log.Fatalf("Unsupported ingest type %s", iType.String())
 	}
-	return nil, fmt.Errorf("unsupported ingest type %s", iType.String())
+	return nil, 0, fmt.Errorf("unsupported ingest type %s", iType.String())
 }
 
 func generateESBody(recs int, actionLine string, rdr utils.Generator,
@@ -114,20 +150,24 @@ func generateESBody(recs int, actionLine string, rdr utils.Generator,
 	return payLoad, nil
 }
 
-func generateOpenTSDBBody(recs int, rdr utils.Generator) ([]byte, error) {
-	finalPayLoad := make([]interface{}, recs)
+func generateOpenTSDBBody(recs int, rdr utils.Generator) ([]byte, int, error) {
+	finalPayLoad := make([]interface{}, 0, recs)
+	var readErr error
 	for i := 0; i < recs; i++ {
 		currPayload, err := rdr.GetRawLog()
-		if err != nil {
-			return nil, err
+		if err == io.EOF {
+			readErr = err
+			break
+		} else if err != nil {
+			return nil, 0, err
 		}
-		finalPayLoad[i] = currPayload
+		finalPayLoad = append(finalPayLoad, currPayload)
 	}
 	retVal, err := json.Marshal(finalPayLoad)
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
-	return retVal, nil
+	return retVal, len(finalPayLoad), readErr
 }
 
 func runIngestion(iType IngestType, rdr utils.Generator, wg *sync.WaitGroup, url string, totalEvents int,
@@ -149,6 +189,20 @@ func runIngestion(iType IngestType, rdr utils.Generator, wg *sync.WaitGroup, url
 	if iType == ESBulk {
 		actLines = populateActionLines(indexPrefix, indexName, numIndices)
 	}
+	// telnet puts are sent over a single connection per process instead of http
+	var telnet *telnetSender
+	if iType == OpenTSDBTelnet {
+		telnet = initTelnetSender(url, processNo)
+		defer telnet.Close()
+	}
+	var precision time.Duration
+	if iType == Influx {
+		var err error
+		precision, err = influxPrecision(url)
+		if err != nil {
+			log.Fatalf("Invalid influx write url %s: %v", url, err)
+		}
+	}
 
 	i := 0
 	var bb *bytebufferpool.ByteBuffer
@@ -162,8 +216,12 @@ func runIngestion(iType IngestType, rdr utils.Generator, wg *sync.WaitGroup, url
 		if iType == ESBulk {
 			bb = bytebufferpool.Get()
 		}
-		payload, err := generateBody(iType, recsInBatch, i, rdr, actLines, bb)
-		if err != nil {
+		payload, recsInBatch, err := generateBody(iType, recsInBatch, i, rdr, actLines, bb, precision)
+		done := err == io.EOF
+		if done && recsInBatch == 0 {
+			return
+		}
+		if err != nil && !done {
 			log.Errorf("Error generating bulk body!: %v", err)
 			if iType == ESBulk {
 				bytebufferpool.Put(bb)
@@ -172,7 +230,11 @@ func runIngestion(iType IngestType, rdr utils.Generator, wg *sync.WaitGroup, url
 		}
 		var reqErr error
 		for i := 0; i < RETRY_COUNT; i++ {
-			reqErr = sendRequest(iType, client, payload, url, bearerToken)
+			if telnet != nil {
+				reqErr = telnet.send(payload)
+			} else {
+				reqErr = sendRequest(iType, client, payload, url, bearerToken)
+			}
 			if reqErr == nil {
 				break
 			}
@@ -190,6 +252,9 @@ func runIngestion(iType IngestType, rdr utils.Generator, wg *sync.WaitGroup, url
 		}
 		eventCounter += recsInBatch
 		atomic.AddUint64(ctr, uint64(recsInBatch))
+		if done {
+			return
+		}
 	}
 }
 
@@ -211,10 +276,17 @@ func populateActionLines(idxPrefix string, indexName string, numIndices int) []s
 	return actionLines
 }
 
-func getReaderFromArgs(iType IngestType, nummetrics int, gentype, str string, ts bool) (utils.Generator, error) {
+// processIdx is 0 based and is used to split input files among processCount readers.
+// Each process gets its own seed derived from seed, so the generated data only depends on seed and processCount.
+// metricsCfg is only used by the metrics readers and can be nil otherwise.
+// tsRewriter is only used by the file based readers and can be nil.
+// injector is only used by the dynamic-user, benchmark and k8s readers and can be nil
+func getReaderFromArgs(iType IngestType, metricsCfg *utils.MetricsConfig, gentype, str string, ts bool, seed int64, tsRewriter *utils.TimestampRewriter,
+	injector *utils.IncidentInjector, processIdx, processCount int) (utils.Generator, error) {
 
-	if iType == OpenTSDB {
-		rdr := utils.InitMetricsGenerator(nummetrics)
+	processSeed := utils.DeriveSeed(seed, processIdx)
+	if iType.isMetrics() {
+		rdr := utils.InitMetricsGenerator(metricsCfg, processSeed, processIdx, processCount)
 		err := rdr.Init(str)
 		return rdr, err
 	}
@@ -222,43 +294,61 @@ func getReaderFromArgs(iType IngestType, nummetrics int, gentype, str string, ts
 	switch gentype {
 	case "", "static":
 		log.Infof("Initializing static reader")
-		rdr = utils.InitStaticGenerator(ts)
+		rdr = utils.InitStaticGenerator(ts, processSeed)
 	case "dynamic-user":
-		seed := int64(fastrand.Uint32n(1_000))
-		rdr = utils.InitDynamicUserGenerator(ts, seed)
+		rdr = utils.InitDynamicUserGenerator(ts, processSeed)
 	case "file":
 		log.Infof("Initializing file reader from %s", str)
-		rdr = utils.InitFileReader()
+		rdr = utils.InitFileReader(utils.FormatJSON, tsRewriter, processIdx, processCount)
+	case utils.FormatCSV, utils.FormatTSV, utils.FormatLogfmt, utils.FormatParquet:
+		log.Infof("Initializing %s file reader from %s", gentype, str)
+		rdr = utils.InitFileReader(gentype, tsRewriter, processIdx, processCount)
 	case "benchmark":
 		log.Infof("Initializing benchmark reader")
-		seed := int64(1001)
-		rdr = utils.InitDynamicUserGenerator(ts, seed)
+		rdr = utils.InitDynamicUserGenerator(ts, processSeed)
 	case "k8s":
 		log.Infof("Initializing k8s reader")
-		seed := int64(1001)
-		rdr = utils.InitK8sGenerator(ts, seed)
+		rdr = utils.InitK8sGenerator(ts, processSeed, seed)
 	default:
-		return nil, fmt.Errorf("unsupported reader type %s. Options=[static,dynamic-user,file,benchmark]", gentype)
+		return nil, fmt.Errorf("unsupported reader type %s. Options=[static,dynamic-user,file,csv,tsv,logfmt,parquet,benchmark,k8s]", gentype)
+	}
+	if injector != nil {
+		switch gentype {
+		case "dynamic-user", "benchmark", "k8s":
+			rdr = utils.InitInjectingGenerator(rdr, injector, utils.DeriveSeed(processSeed, processCount))
+		default:
+			return nil, fmt.Errorf("incidents can only be injected into dynamic-user, benchmark or k8s generators, not %s", gentype)
+		}
 	}
 	err := rdr.Init(str)
 	return rdr, err
 }
 
+// If seed is 0, utils.DefaultSeed is used
 func StartIngestion(iType IngestType, generatorType, dataFile string, totalEvents int, continuous bool,
-	batchSize int, url string, indexPrefix string, indexName string, numIndices, processCount int, addTs bool, nMetrics int, bearerToken string) {
+	batchSize int, url string, indexPrefix string, indexName string, numIndices, processCount int, addTs bool, metricsCfg *utils.MetricsConfig, bearerToken string,
+	seed int64, tsRewriter *utils.TimestampRewriter, injector *utils.IncidentInjector) {
 	log.Printf("Starting ingestion at %+v for %+v", url, iType.String())
+	if seed == 0 {
+		seed = utils.DefaultSeed
+	}
+	log.Infof("Using seed %d for %d processes", seed, processCount)
 	var wg sync.WaitGroup
 	totalEventsPerProcess := totalEvents / processCount
 
 	ticker := time.NewTicker(60 * time.Second)
 	done := make(chan bool)
 	totalSent := uint64(0)
+	trackers := make([]*utils.SeriesTracker, 0)
 	for i := 0; i < processCount; i++ {
 		wg.Add(1)
-		reader, err := getReaderFromArgs(iType, nMetrics, generatorType, dataFile, addTs)
+		reader, err := getReaderFromArgs(iType, metricsCfg, generatorType, dataFile, addTs, seed, tsRewriter, injector, i, processCount)
 		if err != nil {
 			log.Fatalf("StartIngestion: failed to initalize reader! %+v", err)
 		}
+		if mg, ok := reader.(*utils.MetricsGenerator); ok {
+			trackers = append(trackers, mg.SeriesTracker())
+		}
 		go runIngestion(iType, reader, &wg, url, totalEventsPerProcess, continuous, batchSize, i+1, indexPrefix,
 			&totalSent, bearerToken, indexName, numIndices)
 	}
@@ -279,12 +369,23 @@ readChannel:
 			totalTimeTaken := time.Since(startTime)
 			eventsPerSec := int64((totalSent - lastPrintedCount) / 60)
 			log.Infof("Total elapsed time:%s. Total sent events %+v. Events per second:%+v", totalTimeTaken, humanize.Comma(int64(totalSent)), humanize.Comma(eventsPerSec))
-			if iType == OpenTSDB {
-				log.Infof("Approximation of sent number of unique timeseries:%+v", utils.GetMetricsHLL())
+			if iType.isMetrics() {
+				counts := utils.MergeSeriesCounts(trackers)
+				log.Infof("Unique timeseries sent in the last minute:%+v. Since the start:%+v", humanize.Comma(int64(counts.Active)), humanize.Comma(int64(counts.Total)))
 			}
+			writeIncidentManifest(injector)
 			lastPrintedCount = totalSent
 		}
 	}
+	writeIncidentManifest(injector)
+	if iType.isMetrics() {
+		counts := utils.MergeSeriesCounts(trackers)
+		log.Infof("Total unique timeseries sent:%+v", humanize.Comma(int64(counts.Total)))
+		log.Infof("Unique timeseries per metric name:%+v", counts.PerMetric)
+		log.Infof("Unique values per tag key:%+v", counts.PerTagKey)
+	}
+	// readers may run out of events before totalEvents, e.g. when backfilling metrics
+	totalEvents = int(atomic.LoadUint64(&totalSent))
 	log.Printf("Total events ingested:%+d. Event type: %s", totalEvents, iType.String())
 	totalTimeTaken := time.Since(startTime)
 
@@ -296,3 +397,13 @@ readChannel:
 		log.Printf("Total Time Taken for ingestion %s. Average events per second=%+v", totalTimeTaken, humanize.Comma(eventsPerSecond))
 	}
 }
+
+func writeIncidentManifest(injector *utils.IncidentInjector) {
+	if injector == nil {
+		return
+	}
+	err := injector.WriteManifest()
+	if err != nil {
+		log.Errorf("Failed to write incident manifest: %+v", err)
+	}
+}
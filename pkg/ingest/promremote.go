@@ -0,0 +1,142 @@
+package ingest
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"verifier/pkg/utils"
+
+	"github.com/golang/snappy"
+)
+
+// field numbers of the prometheus remote write protobuf messages
+const (
+	// WriteRequest
+	promTimeseriesField = 1
+	// TimeSeries
+	promLabelsField  = 1
+	promSamplesField = 2
+	// Label
+	promLabelNameField  = 1
+	promLabelValueField = 2
+	// Sample
+	promSampleValueField     = 1
+	promSampleTimestampField = 2
+)
+
+const (
+	protoVarint  = 0
+	protoFixed64 = 1
+	protoBytes   = 2
+)
+
+type promSample struct {
+	value     float64
+	timestamp int64
+}
+
+type promSeries struct {
+	labels  [][2]string
+	samples []promSample
+}
+
+// generatePromRemoteWriteBody encodes recs points as a snappy compressed WriteRequest.
+// Points of the same series are sent as samples of a single TimeSeries
+func generatePromRemoteWriteBody(recs int, rdr utils.Generator) ([]byte, int, error) {
+	series := make([]*promSeries, 0)
+	seriesIdx := make(map[string]int)
+	sent := 0
+	var readErr error
+	for ; sent < recs; sent++ {
+		m, err := rdr.GetRawLog()
+		if err == io.EOF {
+			readErr = err
+			break
+		} else if err != nil {
+			return nil, 0, err
+		}
+		labels, key, err := promLabels(m)
+		if err != nil {
+			return nil, 0, err
+		}
+		idx, ok := seriesIdx[key]
+		if !ok {
+			idx = len(series)
+			seriesIdx[key] = idx
+			series = append(series, &promSeries{labels: labels})
+		}
+		ts, ok := m["timestamp"].(int64)
+		if !ok {
+			return nil, 0, fmt.Errorf("unsupported timestamp %+v", m["timestamp"])
+		}
+		value, ok := m["value"].(float64)
+		if !ok {
+			return nil, 0, fmt.Errorf("unsupported value %+v", m["value"])
+		}
+		// remote write timestamps are in millis
+		series[idx].samples = append(series[idx].samples, promSample{value: value, timestamp: ts * 1000})
+	}
+	if readErr != nil && sent == 0 {
+		return nil, 0, readErr
+	}
+
+	req := make([]byte, 0)
+	for _, s := range series {
+		req = appendProtoBytes(req, promTimeseriesField, encodePromSeries(s))
+	}
+	return snappy.Encode(nil, req), sent, readErr
+}
+
+// returns the labels of a generated point sorted by name, as required by remote write, and a key of the series
+func promLabels(m map[string]interface{}) ([][2]string, string, error) {
+	mName, ok := m["metric"].(string)
+	if !ok {
+		return nil, "", fmt.Errorf("unsupported metric name %+v", m["metric"])
+	}
+	tags, _ := m["tags"].(map[string]interface{})
+	labels := make([][2]string, 0, len(tags)+1)
+	labels = append(labels, [2]string{"__name__", mName})
+	for k, v := range tags {
+		labels = append(labels, [2]string{k, fmt.Sprintf("%v", v)})
+	}
+	sort.Slice(labels, func(i, j int) bool { return labels[i][0] < labels[j][0] })
+	key := fmt.Sprintf("%v", labels)
+	return labels, key, nil
+}
+
+func encodePromSeries(s *promSeries) []byte {
+	buf := make([]byte, 0)
+	for _, l := range s.labels {
+		label := appendProtoBytes(nil, promLabelNameField, []byte(l[0]))
+		label = appendProtoBytes(label, promLabelValueField, []byte(l[1]))
+		buf = appendProtoBytes(buf, promLabelsField, label)
+	}
+	for _, sample := range s.samples {
+		enc := appendProtoTag(nil, promSampleValueField, protoFixed64)
+		var fixed [8]byte
+		binary.LittleEndian.PutUint64(fixed[:], math.Float64bits(sample.value))
+		enc = append(enc, fixed[:]...)
+		enc = appendProtoTag(enc, promSampleTimestampField, protoVarint)
+		enc = appendUvarint(enc, uint64(sample.timestamp))
+		buf = appendProtoBytes(buf, promSamplesField, enc)
+	}
+	return buf
+}
+
+func appendProtoTag(buf []byte, field int, wireType int) []byte {
+	return appendUvarint(buf, uint64(field<<3|wireType))
+}
+
+func appendProtoBytes(buf []byte, field int, data []byte) []byte {
+	buf = appendProtoTag(buf, field, protoBytes)
+	buf = appendUvarint(buf, uint64(len(data)))
+	return append(buf, data...)
+}
+
+func appendUvarint(buf []byte, v uint64) []byte {
+	var tmp [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(tmp[:], v)
+	return append(buf, tmp[:n]...)
+}
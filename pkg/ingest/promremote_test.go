@@ -0,0 +1,142 @@
+package ingest
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"testing"
+
+	"github.com/golang/snappy"
+	"github.com/stretchr/testify/assert"
+)
+
+// sliceGenerator returns points and then io.EOF
+type sliceGenerator struct {
+	points []map[string]interface{}
+}
+
+func (g *sliceGenerator) Init(fName ...string) error {
+	return nil
+}
+
+func (g *sliceGenerator) GetLogLine() ([]byte, error) {
+	return nil, fmt.Errorf("not supported")
+}
+
+func (g *sliceGenerator) GetRawLog() (map[string]interface{}, error) {
+	if len(g.points) == 0 {
+		return nil, io.EOF
+	}
+	m := g.points[0]
+	g.points = g.points[1:]
+	return m, nil
+}
+
+// decodes a WriteRequest independently of the encoder, following the field numbers and types of prompb
+func decodeWriteRequest(t *testing.T, buf []byte) []*promSeries {
+	series := make([]*promSeries, 0)
+	for _, f := range decodeProtoFields(t, buf) {
+		assert.Equal(t, 1, f.num, "WriteRequest.timeseries")
+		s := &promSeries{}
+		for _, sf := range decodeProtoFields(t, f.bytes) {
+			switch sf.num {
+			case 1:
+				var label [2]string
+				for _, lf := range decodeProtoFields(t, sf.bytes) {
+					assert.Equal(t, protoBytes, lf.wireType)
+					label[lf.num-1] = string(lf.bytes)
+				}
+				s.labels = append(s.labels, label)
+			case 2:
+				var sample promSample
+				for _, pf := range decodeProtoFields(t, sf.bytes) {
+					switch pf.num {
+					case 1:
+						assert.Equal(t, protoFixed64, pf.wireType)
+						sample.value = math.Float64frombits(pf.value)
+					case 2:
+						// int64 fields are two's complement varints
+						assert.Equal(t, protoVarint, pf.wireType)
+						sample.timestamp = int64(pf.value)
+					}
+				}
+				s.samples = append(s.samples, sample)
+			default:
+				t.Errorf("unexpected TimeSeries field %d", sf.num)
+			}
+		}
+		series = append(series, s)
+	}
+	return series
+}
+
+type protoField struct {
+	num      int
+	wireType int
+	value    uint64
+	bytes    []byte
+}
+
+func decodeProtoFields(t *testing.T, buf []byte) []protoField {
+	fields := make([]protoField, 0)
+	for len(buf) > 0 {
+		tag, n := binary.Uvarint(buf)
+		if !assert.Greater(t, n, 0, "invalid tag") {
+			return fields
+		}
+		buf = buf[n:]
+		f := protoField{num: int(tag >> 3), wireType: int(tag & 7)}
+		switch f.wireType {
+		case protoVarint:
+			f.value, n = binary.Uvarint(buf)
+			if !assert.Greater(t, n, 0, "invalid varint") {
+				return fields
+			}
+			buf = buf[n:]
+		case protoFixed64:
+			if !assert.GreaterOrEqual(t, len(buf), 8, "truncated fixed64") {
+				return fields
+			}
+			f.value = binary.LittleEndian.Uint64(buf)
+			buf = buf[8:]
+		case protoBytes:
+			size, n := binary.Uvarint(buf)
+			if !assert.Greater(t, n, 0, "invalid length") || !assert.LessOrEqual(t, size, uint64(len(buf)-n), "truncated bytes") {
+				return fields
+			}
+			f.bytes = buf[n : n+int(size)]
+			buf = buf[n+int(size):]
+		default:
+			t.Errorf("unexpected wire type %d", f.wireType)
+			return fields
+		}
+		fields = append(fields, f)
+	}
+	return fields
+}
+
+func Test_promRemoteWriteBodyDecodes(t *testing.T) {
+	gen := &sliceGenerator{points: []map[string]interface{}{
+		{"metric": "cpu", "timestamp": int64(1_700_000_000), "value": 0.5, "tags": map[string]interface{}{"host": "a", "zone": "zürich"}},
+		{"metric": "cpu", "timestamp": int64(-5), "value": -1.25, "tags": map[string]interface{}{"zone": "zürich", "host": "a"}},
+		{"metric": "mem", "timestamp": int64(0), "value": math.Inf(1), "tags": map[string]interface{}{"le": "+Inf", "name": "東京"}},
+	}}
+	body, sent, err := generatePromRemoteWriteBody(10, gen)
+	assert.Equal(t, io.EOF, err)
+	assert.Equal(t, 3, sent)
+
+	raw, err := snappy.Decode(nil, body)
+	assert.NoError(t, err)
+	expected := []*promSeries{
+		{
+			labels:  [][2]string{{"__name__", "cpu"}, {"host", "a"}, {"zone", "zürich"}},
+			samples: []promSample{{value: 0.5, timestamp: 1_700_000_000_000}, {value: -1.25, timestamp: -5000}},
+		},
+		{
+			labels:  [][2]string{{"__name__", "mem"}, {"le", "+Inf"}, {"name", "東京"}},
+			samples: []promSample{{value: math.Inf(1), timestamp: 0}},
+		},
+	}
+	assert.Equal(t, expected, decodeWriteRequest(t, raw))
+}
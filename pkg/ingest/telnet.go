@@ -0,0 +1,157 @@
+package ingest
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+	"unicode"
+	"verifier/pkg/utils"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const telnetTimeout = 100 * time.Second
+
+// number of error responses logged per connection before only counting them
+const maxLoggedTelnetErrors = 10
+
+// OTSDB only allows letters, numbers, -, _, . and / in metric names, tag keys and tag values.
+// Other characters are replaced with _, except the +Inf le of histogram buckets, which is sent as inf
+func sanitizeTelnetName(s string) string {
+	if s == "+Inf" {
+		return "inf"
+	}
+	return strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || strings.ContainsRune("-_./", r) {
+			return r
+		}
+		return '_'
+	}, s)
+}
+
+// telnetSender keeps a single connection open and pipelines put lines without waiting for a response.
+// OTSDB only responds to lines that fail, so responses are read in the background and logged
+type telnetSender struct {
+	addr      string
+	conn      net.Conn
+	w         *bufio.Writer
+	errCount  uint64
+	processNo int
+}
+
+func initTelnetSender(dest string, processNo int) *telnetSender {
+	return &telnetSender{
+		addr:      strings.TrimPrefix(dest, "tcp://"),
+		processNo: processNo,
+	}
+}
+
+// send writes all lines in payload. On error the connection is closed and reopened by the next call, so
+// lines of a failed payload may be sent twice when it is retried
+func (ts *telnetSender) send(payload []byte) error {
+	if ts.conn == nil {
+		conn, err := net.DialTimeout("tcp", ts.addr, telnetTimeout)
+		if err != nil {
+			return err
+		}
+		ts.conn = conn
+		ts.w = bufio.NewWriterSize(conn, 64*1024)
+		go ts.readResponses(conn)
+	}
+	err := ts.conn.SetWriteDeadline(time.Now().Add(telnetTimeout))
+	if err == nil {
+		_, err = ts.w.Write(payload)
+	}
+	if err == nil {
+		err = ts.w.Flush()
+	}
+	if err != nil {
+		ts.Close()
+		return err
+	}
+	return nil
+}
+
+func (ts *telnetSender) readResponses(conn net.Conn) {
+	scanner := bufio.NewScanner(conn)
+	for scanner.Scan() {
+		count := atomic.AddUint64(&ts.errCount, 1)
+		if count <= maxLoggedTelnetErrors {
+			log.Errorf("Process %d: OTSDB telnet error from %s: %s", ts.processNo, ts.addr, scanner.Text())
+		}
+	}
+}
+
+func (ts *telnetSender) Close() {
+	if ts.conn == nil {
+		return
+	}
+	ts.conn.Close()
+	ts.conn = nil
+	if count := atomic.LoadUint64(&ts.errCount); count > maxLoggedTelnetErrors {
+		log.Errorf("Process %d: %d lines were rejected by %s", ts.processNo, count, ts.addr)
+	}
+}
+
+// generateOpenTSDBTelnetBody renders recs points as put <metric> <timestamp> <value> <tagk=tagv>... lines
+func generateOpenTSDBTelnetBody(recs int, rdr utils.Generator) ([]byte, int, error) {
+	var sb strings.Builder
+	sent := 0
+	for ; sent < recs; sent++ {
+		m, err := rdr.GetRawLog()
+		if err == io.EOF {
+			if sent == 0 {
+				return nil, 0, err
+			}
+			return []byte(sb.String()), sent, err
+		} else if err != nil {
+			return nil, 0, err
+		}
+		err = appendTelnetLine(&sb, m)
+		if err != nil {
+			return nil, 0, err
+		}
+	}
+	return []byte(sb.String()), sent, nil
+}
+
+func appendTelnetLine(sb *strings.Builder, m map[string]interface{}) error {
+	mName, ok := m["metric"].(string)
+	if !ok {
+		return fmt.Errorf("unsupported metric name %+v", m["metric"])
+	}
+	ts, ok := m["timestamp"].(int64)
+	if !ok {
+		return fmt.Errorf("unsupported timestamp %+v", m["timestamp"])
+	}
+	value, ok := m["value"].(float64)
+	if !ok {
+		return fmt.Errorf("unsupported value %+v", m["value"])
+	}
+	sb.WriteString("put ")
+	sb.WriteString(sanitizeTelnetName(mName))
+	sb.WriteString(" ")
+	sb.WriteString(strconv.FormatInt(ts, 10))
+	sb.WriteString(" ")
+	sb.WriteString(strconv.FormatFloat(value, 'f', -1, 64))
+	tags, _ := m["tags"].(map[string]interface{})
+	keys := make([]string, 0, len(tags))
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		sb.WriteString(" ")
+		sb.WriteString(sanitizeTelnetName(k))
+		sb.WriteString("=")
+		sb.WriteString(sanitizeTelnetName(fmt.Sprintf("%v", tags[k])))
+	}
+	sb.WriteString("\n")
+	return nil
+}
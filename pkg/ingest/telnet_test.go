@@ -0,0 +1,20 @@
+package ingest
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_telnetLineOnlyHasAllowedCharacters(t *testing.T) {
+	var sb strings.Builder
+	err := appendTelnetLine(&sb, map[string]interface{}{
+		"metric":    "testmetric0_bucket",
+		"timestamp": int64(1_700_000_000),
+		"value":     3.5,
+		"tags":      map[string]interface{}{"le": "+Inf", "car type": "SUV=big", "city": "zürich", "path": "/a.b-c:d"},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "put testmetric0_bucket 1700000000 3.5 car_type=SUV_big city=zürich le=inf path=/a.b-c_d\n", sb.String())
+}
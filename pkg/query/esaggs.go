@@ -0,0 +1,307 @@
+package query
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// number of buckets requested by terms aggregations
+const termsSize = 10
+
+// bucket width of the date_histogram query
+const dateHistogramInterval = time.Minute
+
+var latencyPercents = []float64{50, 95, 99}
+
+// returns a query for aggs over the last hour that returns no hits
+func getAggQuery(aggs map[string]interface{}) []byte {
+	var aggQuery = map[string]interface{}{
+		"size":  0,
+		"query": getLastHourFilter(),
+		"aggs":  aggs,
+	}
+	raw, err := json.Marshal(aggQuery)
+	if err != nil {
+		log.Fatalf("error marshalling query: %+v", err)
+	}
+	return raw
+}
+
+// matches all documents with a timestamp in the last hour
+func getLastHourFilter() map[string]interface{} {
+	time := time.Now().UnixMilli()
+	time1hr := time - (1 * 60 * 60 * 1000)
+	return getTimeRangeFilter(time1hr, time)
+}
+
+// filters timestamp between start and end in epoch millis
+func getTimeRangeFilter(start int64, end int64) map[string]interface{} {
+	return map[string]interface{}{
+		"bool": map[string]interface{}{
+			"filter": []interface{}{
+				map[string]interface{}{
+					"range": map[string]interface{}{
+						"timestamp": map[string]interface{}{
+							"gte":    start,
+							"lte":    end,
+							"format": "epoch_millis",
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+// terms on field, in an aggregation named field
+func getTermsAggQuery(field string) []byte {
+	return getAggQuery(map[string]interface{}{
+		field: map[string]interface{}{
+			"terms": map[string]interface{}{
+				"field": field,
+				"size":  termsSize,
+			},
+		},
+	})
+}
+
+// number of events per minute
+func getDateHistogramQuery() []byte {
+	return getAggQuery(map[string]interface{}{
+		"over_time": map[string]interface{}{
+			"date_histogram": map[string]interface{}{
+				"field":          "timestamp",
+				"fixed_interval": fmt.Sprintf("%dm", int(dateHistogramInterval.Minutes())),
+			},
+		},
+	})
+}
+
+// avg latency of each http_method in each city
+func getNestedTermsAvgQuery() []byte {
+	return getAggQuery(map[string]interface{}{
+		"city": map[string]interface{}{
+			"terms": map[string]interface{}{
+				"field": "city",
+				"size":  termsSize,
+			},
+			"aggs": map[string]interface{}{
+				"http_method": map[string]interface{}{
+					"terms": map[string]interface{}{
+						"field": "http_method",
+						"size":  termsSize,
+					},
+					"aggs": map[string]interface{}{
+						"avg_latency": map[string]interface{}{
+							"avg": map[string]interface{}{
+								"field": "latency",
+							},
+						},
+					},
+				},
+			},
+		},
+	})
+}
+
+func getCardinalityQuery() []byte {
+	return getAggQuery(map[string]interface{}{
+		"unique_idents": map[string]interface{}{
+			"cardinality": map[string]interface{}{
+				"field": "ident",
+			},
+		},
+	})
+}
+
+func getPercentilesQuery() []byte {
+	return getAggQuery(map[string]interface{}{
+		"latency_percentiles": map[string]interface{}{
+			"percentiles": map[string]interface{}{
+				"field":    "latency",
+				"percents": latencyPercents,
+			},
+		},
+	})
+}
+
+// checkAggregations returns a description of every problem with the structure of the aggregations of qType
+func checkAggregations(qType logsQueryTypes, esOutput map[string]interface{}) []string {
+	aggs, ok := esOutput["aggregations"].(map[string]interface{})
+	if !ok {
+		return []string{"response has no aggregations"}
+	}
+	switch qType {
+	case termsCityAgg:
+		return checkTermsBuckets(aggs, "city", nil)
+	case termsHttpMethodAgg:
+		return checkTermsBuckets(aggs, "http_method", nil)
+	case dateHistogramAgg:
+		return checkDateHistogram(aggs)
+	case nestedTermsAvgAgg:
+		return checkTermsBuckets(aggs, "city", func(bucket map[string]interface{}, docCount float64) []string {
+			return checkTermsBuckets(bucket, "http_method", func(sub map[string]interface{}, subDocCount float64) []string {
+				return checkAvg(sub, "avg_latency", subDocCount)
+			})
+		})
+	case cardinalityAgg:
+		agg, ok := aggs["unique_idents"].(map[string]interface{})
+		if !ok {
+			return []string{"missing aggregation unique_idents"}
+		}
+		value, ok := agg["value"].(float64)
+		if !ok || value < 0 || value != math.Trunc(value) {
+			return []string{fmt.Sprintf("unique_idents value %v is not a count", agg["value"])}
+		}
+		return nil
+	case percentilesAgg:
+		return checkPercentiles(aggs)
+	default:
+		return []string{fmt.Sprintf("%v is not an aggregation query", qType)}
+	}
+}
+
+// checks that the buckets of terms aggregation name are sorted by doc_count and have at most termsSize keys.
+// checkBucket, if set, checks the sub aggregations of each bucket
+func checkTermsBuckets(aggs map[string]interface{}, name string, checkBucket func(map[string]interface{}, float64) []string) []string {
+	buckets, problems := getBuckets(aggs, name)
+	if problems != nil {
+		return problems
+	}
+	if len(buckets) > termsSize {
+		problems = append(problems, fmt.Sprintf("%s has %d buckets, more than the size %d", name, len(buckets), termsSize))
+	}
+	agg := aggs[name].(map[string]interface{})
+	if other, ok := agg["sum_other_doc_count"].(float64); !ok || other < 0 {
+		problems = append(problems, fmt.Sprintf("%s has an invalid sum_other_doc_count %v", name, agg["sum_other_doc_count"]))
+	}
+	prevCount := math.Inf(1)
+	seen := make(map[string]bool)
+	for i, bucket := range buckets {
+		key, ok := bucket["key"].(string)
+		if !ok || seen[key] {
+			problems = append(problems, fmt.Sprintf("%s bucket %d has a missing or repeated key %v", name, i, bucket["key"]))
+		}
+		seen[key] = true
+		docCount, ok := bucket["doc_count"].(float64)
+		if !ok || docCount <= 0 {
+			problems = append(problems, fmt.Sprintf("%s bucket %v has an invalid doc_count %v", name, key, bucket["doc_count"]))
+			continue
+		}
+		if docCount > prevCount {
+			problems = append(problems, fmt.Sprintf("%s buckets are not sorted by doc_count at %v", name, key))
+		}
+		prevCount = docCount
+		if checkBucket != nil {
+			for _, p := range checkBucket(bucket, docCount) {
+				problems = append(problems, fmt.Sprintf("%s=%v: %s", name, key, p))
+			}
+		}
+	}
+	return problems
+}
+
+// checks that the buckets of the date_histogram are in order and evenly spaced
+func checkDateHistogram(aggs map[string]interface{}) []string {
+	buckets, problems := getBuckets(aggs, "over_time")
+	if problems != nil {
+		return problems
+	}
+	interval := float64(dateHistogramInterval.Milliseconds())
+	for i, bucket := range buckets {
+		key, ok := bucket["key"].(float64)
+		if !ok || math.Mod(key, interval) != 0 {
+			problems = append(problems, fmt.Sprintf("over_time bucket %d key %v is not a multiple of %vms", i, formatESKey(bucket["key"]), interval))
+			continue
+		}
+		if i > 0 {
+			prevKey, _ := buckets[i-1]["key"].(float64)
+			if key-prevKey != interval {
+				problems = append(problems, fmt.Sprintf("over_time bucket %d does not follow %d", int64(key), int64(prevKey)))
+			}
+		}
+		if docCount, ok := bucket["doc_count"].(float64); !ok || docCount < 0 {
+			problems = append(problems, fmt.Sprintf("over_time bucket %d has an invalid doc_count %v", int64(key), bucket["doc_count"]))
+		}
+	}
+	return problems
+}
+
+// checks that avg aggregation name has a value, or null if there are no documents
+func checkAvg(aggs map[string]interface{}, name string, docCount float64) []string {
+	agg, ok := aggs[name].(map[string]interface{})
+	if !ok {
+		return []string{fmt.Sprintf("missing aggregation %s", name)}
+	}
+	if _, ok := agg["value"].(float64); !ok && !(docCount == 0 && agg["value"] == nil) {
+		return []string{fmt.Sprintf("%s has an invalid value %v", name, agg["value"])}
+	}
+	return nil
+}
+
+// checks that every requested percentile is returned and that they do not decrease
+func checkPercentiles(aggs map[string]interface{}) []string {
+	agg, ok := aggs["latency_percentiles"].(map[string]interface{})
+	if !ok {
+		return []string{"missing aggregation latency_percentiles"}
+	}
+	values, ok := agg["values"].(map[string]interface{})
+	if !ok {
+		return []string{fmt.Sprintf("latency_percentiles has invalid values %v", agg["values"])}
+	}
+	problems := make([]string, 0)
+	prev := math.Inf(-1)
+	for _, percent := range latencyPercents {
+		key := fmt.Sprintf("%.1f", percent)
+		raw, ok := values[key]
+		if !ok {
+			problems = append(problems, fmt.Sprintf("latency_percentiles is missing %s", key))
+			continue
+		}
+		value, ok := raw.(float64)
+		if !ok {
+			// null if there are no documents
+			if raw != nil {
+				problems = append(problems, fmt.Sprintf("latency_percentiles %s has an invalid value %v", key, raw))
+			}
+			continue
+		}
+		if value < prev {
+			problems = append(problems, fmt.Sprintf("latency_percentiles %s=%v is smaller than a lower percentile", key, value))
+		}
+		prev = value
+	}
+	return problems
+}
+
+// epoch millis keys are decoded as float64
+func formatESKey(key interface{}) interface{} {
+	if f, ok := key.(float64); ok && f == math.Trunc(f) {
+		return int64(f)
+	}
+	return key
+}
+
+func getBuckets(aggs map[string]interface{}, name string) ([]map[string]interface{}, []string) {
+	agg, ok := aggs[name].(map[string]interface{})
+	if !ok {
+		return nil, []string{fmt.Sprintf("missing aggregation %s", name)}
+	}
+	rawBuckets, ok := agg["buckets"].([]interface{})
+	if !ok {
+		return nil, []string{fmt.Sprintf("%s has no list of buckets", name)}
+	}
+	buckets := make([]map[string]interface{}, 0, len(rawBuckets))
+	for i, raw := range rawBuckets {
+		bucket, ok := raw.(map[string]interface{})
+		if !ok {
+			return nil, []string{fmt.Sprintf("%s bucket %d is not an object", name, i)}
+		}
+		buckets = append(buckets, bucket)
+	}
+	return buckets, nil
+}
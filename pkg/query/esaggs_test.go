@@ -0,0 +1,149 @@
+package query
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func parseESResponse(t *testing.T, raw string) map[string]interface{} {
+	m := make(map[string]interface{})
+	assert.NoError(t, json.Unmarshal([]byte(raw), &m), raw)
+	return m
+}
+
+func Test_checkAggregations(t *testing.T) {
+	cases := []struct {
+		name     string
+		qType    logsQueryTypes
+		response string
+		problems int
+	}{
+		{
+			name:     "terms",
+			qType:    termsCityAgg,
+			response: `{"aggregations": {"city": {"sum_other_doc_count": 3, "buckets": [{"key": "Boston", "doc_count": 5}, {"key": "Austin", "doc_count": 5}, {"key": "Denver", "doc_count": 1}]}}}`,
+		},
+		{
+			name:     "terms unsorted",
+			qType:    termsCityAgg,
+			response: `{"aggregations": {"city": {"sum_other_doc_count": 0, "buckets": [{"key": "Boston", "doc_count": 1}, {"key": "Austin", "doc_count": 5}]}}}`,
+			problems: 1,
+		},
+		{
+			name:     "terms repeated key",
+			qType:    termsHttpMethodAgg,
+			response: `{"aggregations": {"http_method": {"sum_other_doc_count": 0, "buckets": [{"key": "GET", "doc_count": 5}, {"key": "GET", "doc_count": 4}]}}}`,
+			problems: 1,
+		},
+		{
+			name:     "terms invalid doc_count and sum_other_doc_count",
+			qType:    termsHttpMethodAgg,
+			response: `{"aggregations": {"http_method": {"buckets": [{"key": "GET", "doc_count": 0}]}}}`,
+			problems: 2,
+		},
+		{
+			name:     "terms too many buckets",
+			qType:    termsCityAgg,
+			response: `{"aggregations": {"city": {"sum_other_doc_count": 0, "buckets": [{"key": "a", "doc_count": 1}, {"key": "b", "doc_count": 1}, {"key": "c", "doc_count": 1}, {"key": "d", "doc_count": 1}, {"key": "e", "doc_count": 1}, {"key": "f", "doc_count": 1}, {"key": "g", "doc_count": 1}, {"key": "h", "doc_count": 1}, {"key": "i", "doc_count": 1}, {"key": "j", "doc_count": 1}, {"key": "k", "doc_count": 1}]}}}`,
+			problems: 1,
+		},
+		{
+			name:     "date histogram",
+			qType:    dateHistogramAgg,
+			response: `{"aggregations": {"over_time": {"buckets": [{"key": 1700000040000, "doc_count": 3}, {"key": 1700000100000, "doc_count": 0}, {"key": 1700000160000, "doc_count": 2}]}}}`,
+		},
+		{
+			name:     "date histogram gap",
+			qType:    dateHistogramAgg,
+			response: `{"aggregations": {"over_time": {"buckets": [{"key": 1700000040000, "doc_count": 3}, {"key": 1700000160000, "doc_count": 2}]}}}`,
+			problems: 1,
+		},
+		{
+			name:     "date histogram unaligned key",
+			qType:    dateHistogramAgg,
+			response: `{"aggregations": {"over_time": {"buckets": [{"key": 1700000041000, "doc_count": 3}]}}}`,
+			problems: 1,
+		},
+		{
+			name:     "nested terms avg",
+			qType:    nestedTermsAvgAgg,
+			response: `{"aggregations": {"city": {"sum_other_doc_count": 0, "buckets": [{"key": "Boston", "doc_count": 3, "http_method": {"sum_other_doc_count": 0, "buckets": [{"key": "GET", "doc_count": 2, "avg_latency": {"value": 12.5}}, {"key": "PUT", "doc_count": 1, "avg_latency": {"value": 3}}]}}]}}}`,
+		},
+		{
+			name:     "nested terms null avg",
+			qType:    nestedTermsAvgAgg,
+			response: `{"aggregations": {"city": {"sum_other_doc_count": 0, "buckets": [{"key": "Boston", "doc_count": 3, "http_method": {"sum_other_doc_count": 0, "buckets": [{"key": "GET", "doc_count": 3, "avg_latency": {"value": null}}]}}]}}}`,
+			problems: 1,
+		},
+		{
+			name:     "nested terms missing avg",
+			qType:    nestedTermsAvgAgg,
+			response: `{"aggregations": {"city": {"sum_other_doc_count": 0, "buckets": [{"key": "Boston", "doc_count": 3, "http_method": {"sum_other_doc_count": 0, "buckets": [{"key": "GET", "doc_count": 3}]}}]}}}`,
+			problems: 1,
+		},
+		{
+			name:     "cardinality",
+			qType:    cardinalityAgg,
+			response: `{"aggregations": {"unique_idents": {"value": 42}}}`,
+		},
+		{
+			name:     "cardinality not a count",
+			qType:    cardinalityAgg,
+			response: `{"aggregations": {"unique_idents": {"value": 4.2}}}`,
+			problems: 1,
+		},
+		{
+			name:     "percentiles",
+			qType:    percentilesAgg,
+			response: `{"aggregations": {"latency_percentiles": {"values": {"50.0": 10, "95.0": 90, "99.0": 99}}}}`,
+		},
+		{
+			// there are no documents
+			name:     "null percentiles",
+			qType:    percentilesAgg,
+			response: `{"aggregations": {"latency_percentiles": {"values": {"50.0": null, "95.0": null, "99.0": null}}}}`,
+		},
+		{
+			name:     "decreasing percentiles",
+			qType:    percentilesAgg,
+			response: `{"aggregations": {"latency_percentiles": {"values": {"50.0": 10, "95.0": 9, "99.0": 99}}}}`,
+			problems: 1,
+		},
+		{
+			name:     "missing percentile",
+			qType:    percentilesAgg,
+			response: `{"aggregations": {"latency_percentiles": {"values": {"50.0": 10, "99.0": 99}}}}`,
+			problems: 1,
+		},
+		{
+			name:     "no aggregations",
+			qType:    termsCityAgg,
+			response: `{"hits": {"hits": []}}`,
+			problems: 1,
+		},
+		{
+			name:     "missing aggregation",
+			qType:    dateHistogramAgg,
+			response: `{"aggregations": {"city": {"buckets": []}}}`,
+			problems: 1,
+		},
+		{
+			name:     "not an aggregation query",
+			qType:    matchAll,
+			response: `{"aggregations": {}}`,
+			problems: 1,
+		},
+	}
+	for _, tc := range cases {
+		assert.Len(t, checkAggregations(tc.qType, parseESResponse(t, tc.response)), tc.problems, tc.name)
+	}
+}
+
+func Test_checkAvg(t *testing.T) {
+	// avg is null only if there are no documents
+	aggs := parseESResponse(t, `{"avg_latency": {"value": null}}`)
+	assert.Empty(t, checkAvg(aggs, "avg_latency", 0))
+	assert.Len(t, checkAvg(aggs, "avg_latency", 1), 1)
+}
@@ -0,0 +1,137 @@
+package query
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// returns hits.total of a search response, which is an object in ES 7+ and a number before
+func getHitsTotal(esOutput map[string]interface{}) (float64, bool) {
+	rawHits, ok := esOutput["hits"].(map[string]interface{})
+	if !ok {
+		return 0, false
+	}
+	switch total := rawHits["total"].(type) {
+	case map[string]interface{}:
+		value, ok := total["value"].(float64)
+		return value, ok
+	case float64:
+		return total, true
+	default:
+		return 0, false
+	}
+}
+
+// returns an _msearch body that runs each of bodies on target
+func getMsearchBody(target string, bodies [][]byte) []byte {
+	header, err := json.Marshal(map[string]interface{}{"index": target})
+	if err != nil {
+		log.Fatalf("error marshalling msearch header: %+v", err)
+	}
+	var buf bytes.Buffer
+	for _, body := range bodies {
+		buf.Write(header)
+		buf.WriteByte('\n')
+		buf.Write(body)
+		buf.WriteByte('\n')
+	}
+	return buf.Bytes()
+}
+
+// msearchSubResult is the took and hits of a single search of an _msearch batch, or its error
+type msearchSubResult struct {
+	took float64
+	hits float64
+	err  error
+}
+
+// sends bodies as one _msearch batch on target. Returns the took of the batch and the result of each search
+func sendMsearchRequest(client *http.Client, url string, target string, bodies [][]byte, authToken string) (float64, []msearchSubResult) {
+	m := sendESRequest(client, "POST", getMsearchBody(target, bodies), url, authToken)
+	took, _ := m["took"].(float64)
+	rawResponses, ok := m["responses"].([]interface{})
+	subResults := make([]msearchSubResult, len(bodies))
+	for i := range bodies {
+		if !ok || i >= len(rawResponses) {
+			subResults[i].err = fmt.Errorf("missing response")
+			continue
+		}
+		resp, isMap := rawResponses[i].(map[string]interface{})
+		if !isMap {
+			subResults[i].err = fmt.Errorf("response is not an object")
+			continue
+		}
+		if rawErr, hasErr := resp["error"]; hasErr {
+			subResults[i].err = fmt.Errorf("status %v: %v", resp["status"], rawErr)
+			continue
+		}
+		subResults[i].took, _ = resp["took"].(float64)
+		hits, hasHits := getHitsTotal(resp)
+		if !hasHits {
+			subResults[i].err = fmt.Errorf("response has no hits.total")
+			continue
+		}
+		subResults[i].hits = hits
+	}
+	return took, subResults
+}
+
+// Returns the elapsed time measured by the client, since _count responses have no took, and the count
+func sendCountRequest(client *http.Client, url string, authToken string) (float64, float64) {
+	body, err := json.Marshal(map[string]interface{}{"query": getLastHourFilter()})
+	if err != nil {
+		log.Fatalf("error marshalling query: %+v", err)
+	}
+	stime := time.Now()
+	m := sendESRequest(client, "POST", body, url, authToken)
+	elapsed := float64(time.Since(stime).Milliseconds())
+	count, ok := m["count"].(float64)
+	if !ok {
+		log.Errorf("count query: response has no count %+v", m)
+	}
+	return elapsed, count
+}
+
+// sendMsearchBatch sends size of the filter queries in bodies as one _msearch batch, cycling through them in the order
+// of filterQueryTypes, and logs the took and hits of each search next to those of the individual search of the same body.
+// Returns the took of the batch, the took of each search by query type and false if any search failed
+func sendMsearchBatch(client *http.Client, url string, target string, size int, bodies map[logsQueryTypes][]byte,
+	hits map[logsQueryTypes]float64, tooks map[logsQueryTypes]float64, authToken string) (float64, map[logsQueryTypes][]float64, bool) {
+	qTypes := make([]logsQueryTypes, 0, len(filterQueryTypes))
+	for _, qType := range filterQueryTypes {
+		if _, ok := bodies[qType]; ok {
+			qTypes = append(qTypes, qType)
+		}
+	}
+	batchTypes := make([]logsQueryTypes, size)
+	batch := make([][]byte, size)
+	for i := range batch {
+		batchTypes[i] = qTypes[i%len(qTypes)]
+		batch[i] = bodies[batchTypes[i]]
+	}
+
+	took, subResults := sendMsearchRequest(client, url, target, batch, authToken)
+	log.Infof("msearch batch of %d searches: [%+v]ms", size, took)
+	subTooks := make(map[logsQueryTypes][]float64)
+	valid := true
+	for i, sub := range subResults {
+		qType := batchTypes[i]
+		if sub.err != nil {
+			log.Errorf("msearch %s query: %v", qType.String(), sub.err)
+			valid = false
+			continue
+		}
+		subTooks[qType] = append(subTooks[qType], sub.took)
+		log.Infof("msearch %s query: [%+v]ms. Hits: %v. Individual search: [%+v]ms. Hits: %v", qType.String(), sub.took, sub.hits, tooks[qType], hits[qType])
+		// documents ingested between the two requests can change the hits
+		if sub.hits != hits[qType] {
+			log.Warnf("msearch %s query returned %v hits, the individual search returned %v", qType.String(), sub.hits, hits[qType])
+		}
+	}
+	return took, subTooks, valid
+}
@@ -0,0 +1,138 @@
+package query
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_getMsearchBody(t *testing.T) {
+	bodies := [][]byte{[]byte(`{"query":{"match_all":{}}}`), []byte(`{"size":0}`), []byte(`{"query":{"match_all":{}}}`)}
+	body := getMsearchBody("logs-*", bodies)
+	// ndjson must end with a newline
+	assert.True(t, bytes.HasSuffix(body, []byte("\n")))
+
+	lines := bytes.Split(bytes.TrimSuffix(body, []byte("\n")), []byte("\n"))
+	assert.Len(t, lines, 2*len(bodies))
+	for i := 0; i+1 < len(lines); i += 2 {
+		assert.JSONEq(t, `{"index":"logs-*"}`, string(lines[i]))
+		assert.Equal(t, bodies[i/2], lines[i+1])
+	}
+	assert.Empty(t, getMsearchBody("logs-*", nil))
+}
+
+func Test_getHitsTotal(t *testing.T) {
+	cases := []struct {
+		response string
+		total    float64
+		ok       bool
+	}{
+		{`{"hits": {"total": {"value": 10, "relation": "eq"}}}`, 10, true},
+		// ES 6 and older
+		{`{"hits": {"total": 7}}`, 7, true},
+		{`{"hits": {"total": "many"}}`, 0, false},
+		{`{"hits": {}}`, 0, false},
+		{`{"error": "index not found"}`, 0, false},
+	}
+	for _, tc := range cases {
+		total, ok := getHitsTotal(parseESResponse(t, tc.response))
+		assert.Equal(t, tc.ok, ok, tc.response)
+		assert.Equal(t, tc.total, total, tc.response)
+	}
+}
+
+// returns a server that answers _msearch requests with responses, and the bodies it received
+func newMsearchServer(t *testing.T, responses string) (*httptest.Server, *[][]byte) {
+	received := make([][]byte, 0)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/_msearch", r.URL.Path)
+		body, err := ioutil.ReadAll(r.Body)
+		assert.NoError(t, err)
+		received = append(received, body)
+		fmt.Fprint(w, responses)
+	}))
+	return server, &received
+}
+
+func Test_sendMsearchRequest(t *testing.T) {
+	responses := `{"took": 12, "responses": [
+		{"took": 3, "hits": {"total": {"value": 5}}},
+		{"status": 400, "error": {"type": "parsing_exception"}},
+		{"took": 2, "hits": {}},
+		"oops"
+	]}`
+	server, received := newMsearchServer(t, responses)
+	defer server.Close()
+
+	bodies := [][]byte{[]byte(`{"size":1}`), []byte(`{"size":2}`), []byte(`{"size":3}`), []byte(`{"size":4}`), []byte(`{"size":5}`)}
+	took, subResults := sendMsearchRequest(server.Client(), server.URL+"/_msearch", "logs-*", bodies, "")
+	assert.Equal(t, 12.0, took)
+	assert.Equal(t, [][]byte{getMsearchBody("logs-*", bodies)}, *received)
+
+	if assert.Len(t, subResults, len(bodies)) {
+		assert.NoError(t, subResults[0].err)
+		assert.Equal(t, 3.0, subResults[0].took)
+		assert.Equal(t, 5.0, subResults[0].hits)
+		// each failed search has its own error
+		assert.Contains(t, subResults[1].err.Error(), "400")
+		assert.Contains(t, subResults[2].err.Error(), "hits.total")
+		assert.Contains(t, subResults[3].err.Error(), "not an object")
+		assert.Contains(t, subResults[4].err.Error(), "missing")
+	}
+}
+
+func Test_sendMsearchBatch(t *testing.T) {
+	bodies := make(map[logsQueryTypes][]byte)
+	hits := make(map[logsQueryTypes]float64)
+	tooks := make(map[logsQueryTypes]float64)
+	for _, qType := range []logsQueryTypes{matchAll, matchRange} {
+		bodies[qType], _ = json.Marshal(map[string]interface{}{"query": qType.String()})
+		hits[qType] = 5
+	}
+	valid := `{"took": 3, "hits": {"total": {"value": 5}}}`
+
+	cases := []struct {
+		name      string
+		responses []string
+		valid     bool
+		subTooks  map[logsQueryTypes][]float64
+	}{
+		{
+			name:      "all searches succeed",
+			responses: []string{valid, valid, valid},
+			valid:     true,
+			subTooks:  map[logsQueryTypes][]float64{matchAll: {3, 3}, matchRange: {3}},
+		},
+		{
+			name:      "one search fails",
+			responses: []string{valid, `{"status": 500, "error": "shard failure"}`, valid},
+			valid:     false,
+			subTooks:  map[logsQueryTypes][]float64{matchAll: {3, 3}},
+		},
+		{
+			name:      "a response is missing",
+			responses: []string{valid, valid},
+			valid:     false,
+			subTooks:  map[logsQueryTypes][]float64{matchAll: {3}, matchRange: {3}},
+		},
+	}
+	for _, tc := range cases {
+		raw := fmt.Sprintf(`{"took": 9, "responses": [%s]}`, strings.Join(tc.responses, ","))
+		server, received := newMsearchServer(t, raw)
+		took, subTooks, ok := sendMsearchBatch(server.Client(), server.URL+"/_msearch", "logs-*", 3, bodies, hits, tooks, "")
+		server.Close()
+		assert.Equal(t, 9.0, took, tc.name)
+		assert.Equal(t, tc.valid, ok, tc.name)
+		assert.Equal(t, tc.subTooks, subTooks, tc.name)
+		// the batch cycles through the filter query types in order
+		expected := getMsearchBody("logs-*", [][]byte{bodies[matchAll], bodies[matchRange], bodies[matchAll]})
+		assert.Equal(t, [][]byte{expected}, *received, tc.name)
+	}
+}
@@ -0,0 +1,247 @@
+package query
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// number of latest documents fetched by the tail query
+	tailSize = 500
+	// page size of search_after and scroll
+	pageSize = 500
+	// page size of from/size. Kept small so all pages stay below the max result window of 10000
+	fromSizePageSize = 100
+	// max number of pages fetched by each pagination query
+	maxPages        = 10
+	scrollKeepAlive = "1m"
+)
+
+// fields returned by the tail query
+var tailSourceFields = []string{"timestamp", "city", "http_method", "latency"}
+
+// documents are sorted by latest timestamp. Ties are broken by _doc, the position of the document in its shard, which
+// every index has. Documents of different shards can have the same _doc, in which case they are in shard order
+var paginationSort = []interface{}{
+	map[string]interface{}{"timestamp": map[string]interface{}{"order": "desc"}},
+	map[string]interface{}{"_doc": map[string]interface{}{"order": "asc"}},
+}
+
+// returns a sorted query with rangeFilter. from, searchAfter and sourceFields are only set if not empty
+func getSortedQuery(rangeFilter map[string]interface{}, size int, from int, searchAfter []interface{}, sourceFields []string) []byte {
+	var sortedQuery = map[string]interface{}{
+		"size":  size,
+		"sort":  paginationSort,
+		"query": rangeFilter,
+	}
+	if from > 0 {
+		sortedQuery["from"] = from
+	}
+	if len(searchAfter) > 0 {
+		sortedQuery["search_after"] = searchAfter
+	}
+	if len(sourceFields) > 0 {
+		sortedQuery["_source"] = sourceFields
+	}
+	raw, err := json.Marshal(sortedQuery)
+	if err != nil {
+		log.Fatalf("error marshalling query: %+v", err)
+	}
+	return raw
+}
+
+// pageChecker checks that the hits of consecutive pages are sorted by paginationSort and that no document repeats
+type pageChecker struct {
+	// if set, _source may only have these fields
+	sourceFields []string
+	seen         map[string]bool
+	prevSort     []interface{}
+	problems     []string
+}
+
+func newPageChecker(sourceFields []string) *pageChecker {
+	return &pageChecker{sourceFields: sourceFields, seen: make(map[string]bool), problems: make([]string, 0)}
+}
+
+// addPage checks the hits of a page and returns their number and the sort values of the last hit
+func (pc *pageChecker) addPage(esOutput map[string]interface{}) (int, []interface{}) {
+	hits, err := getHitsList(esOutput)
+	if err != nil {
+		pc.problems = append(pc.problems, err.Error())
+		return 0, nil
+	}
+	for _, hit := range hits {
+		id := fmt.Sprintf("%v/%v", hit["_index"], hit["_id"])
+		if pc.seen[id] {
+			pc.problems = append(pc.problems, fmt.Sprintf("document %s is returned more than once", id))
+		}
+		pc.seen[id] = true
+
+		sortValues, ok := hit["sort"].([]interface{})
+		if !ok || len(sortValues) != len(paginationSort) {
+			pc.problems = append(pc.problems, fmt.Sprintf("document %s has invalid sort values %v", id, hit["sort"]))
+			continue
+		}
+		if pc.prevSort != nil && !sortedAfter(pc.prevSort, sortValues) {
+			pc.problems = append(pc.problems, fmt.Sprintf("document %s with sort values %v is not sorted after %v", id, sortValues, pc.prevSort))
+		}
+		pc.prevSort = sortValues
+
+		if len(pc.sourceFields) > 0 {
+			source, _ := hit["_source"].(map[string]interface{})
+			for k := range source {
+				if !containsString(pc.sourceFields, k) {
+					pc.problems = append(pc.problems, fmt.Sprintf("document %s has field %s, which is not in _source", id, k))
+				}
+			}
+		}
+	}
+	return len(hits), pc.prevSort
+}
+
+// returns true if next can follow prev with descending timestamps and ascending _doc. _doc may repeat across shards
+func sortedAfter(prev, next []interface{}) bool {
+	prevTs, ok1 := prev[0].(float64)
+	nextTs, ok2 := next[0].(float64)
+	if !ok1 || !ok2 {
+		return false
+	}
+	if nextTs != prevTs {
+		return nextTs < prevTs
+	}
+	prevDoc, ok1 := prev[1].(float64)
+	nextDoc, ok2 := next[1].(float64)
+	return ok1 && ok2 && nextDoc >= prevDoc
+}
+
+func getHitsList(esOutput map[string]interface{}) ([]map[string]interface{}, error) {
+	rawHits, ok := esOutput["hits"].(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("response has no hits")
+	}
+	hitsList, ok := rawHits["hits"].([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("hits.hits is not a list")
+	}
+	hits := make([]map[string]interface{}, 0, len(hitsList))
+	for i, raw := range hitsList {
+		hit, ok := raw.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("hit %d is not an object", i)
+		}
+		hits = append(hits, hit)
+	}
+	return hits, nil
+}
+
+func containsString(list []string, s string) bool {
+	for _, l := range list {
+		if l == s {
+			return true
+		}
+	}
+	return false
+}
+
+// sends all pages of a pagination query type over the last hour. The time range is fixed when the first page is sent,
+// so documents ingested while paging do not shift the later pages.
+// Returns the took of the first page, the total took of all pages and, if validatePages, false and logs the problems
+// if the hits are not sorted, a document repeats or _source has extra fields
+func sendPaginationRequests(qType logsQueryTypes, client *http.Client, dest string, url string, verbose bool, authToken string, validatePages bool) (float64, float64, bool) {
+	rangeFilter := getLastHourFilter()
+	var firstPage, allPages float64
+	numTooks := 0
+	addTook := func(m map[string]interface{}) {
+		took := validateAndGetElapsedTime(qType, m, verbose)
+		if numTooks == 0 {
+			firstPage = took
+		}
+		numTooks++
+		allPages += took
+	}
+
+	var checker *pageChecker
+	numPages := 0
+	switch qType {
+	case tailLatest:
+		checker = newPageChecker(tailSourceFields)
+		m := sendESRequest(client, "POST", getSortedQuery(rangeFilter, tailSize, 0, nil, tailSourceFields), url, authToken)
+		addTook(m)
+		numHits, _ := checker.addPage(m)
+		if numHits > tailSize {
+			checker.problems = append(checker.problems, fmt.Sprintf("returned %d hits, more than the size %d", numHits, tailSize))
+		}
+		numPages = 1
+	case fromSizePagination:
+		checker = newPageChecker(nil)
+		for ; numPages < maxPages; numPages++ {
+			m := sendESRequest(client, "POST", getSortedQuery(rangeFilter, fromSizePageSize, numPages*fromSizePageSize, nil, nil), url, authToken)
+			addTook(m)
+			numHits, _ := checker.addPage(m)
+			if numHits < fromSizePageSize {
+				numPages++
+				break
+			}
+		}
+	case searchAfterPagination:
+		checker = newPageChecker(nil)
+		var searchAfter []interface{}
+		for ; numPages < maxPages; numPages++ {
+			m := sendESRequest(client, "POST", getSortedQuery(rangeFilter, pageSize, 0, searchAfter, nil), url, authToken)
+			addTook(m)
+			var numHits int
+			numHits, searchAfter = checker.addPage(m)
+			if numHits < pageSize {
+				numPages++
+				break
+			}
+		}
+	case scrollPagination:
+		checker = newPageChecker(nil)
+		m := sendESRequest(client, "POST", getSortedQuery(rangeFilter, pageSize, 0, nil, nil), fmt.Sprintf("%s?scroll=%s", url, scrollKeepAlive), authToken)
+		for numPages < maxPages {
+			addTook(m)
+			numPages++
+			numHits, _ := checker.addPage(m)
+			scrollId, ok := m["_scroll_id"].(string)
+			if !ok {
+				checker.problems = append(checker.problems, "response has no _scroll_id")
+				break
+			}
+			if numHits < pageSize || numPages == maxPages {
+				clearScroll(client, dest, scrollId, authToken)
+				break
+			}
+			body, _ := json.Marshal(map[string]interface{}{"scroll": scrollKeepAlive, "scroll_id": scrollId})
+			m = sendESRequest(client, "POST", body, fmt.Sprintf("%s/_search/scroll", dest), authToken)
+		}
+	default:
+		log.Fatalf("%s is not a pagination query", qType.String())
+	}
+
+	log.Infof("%s query: fetched %d documents in %d pages", qType.String(), len(checker.seen), numPages)
+	if !validatePages {
+		return firstPage, allPages, true
+	}
+	for i, p := range checker.problems {
+		if i == maxLoggedMismatches {
+			log.Errorf("%s query: %d more problems", qType.String(), len(checker.problems)-maxLoggedMismatches)
+			break
+		}
+		log.Errorf("%s query: %s", qType.String(), p)
+	}
+	return firstPage, allPages, len(checker.problems) == 0
+}
+
+// frees the scroll context instead of waiting for it to expire
+func clearScroll(client *http.Client, dest string, scrollId string, authToken string) {
+	body, _ := json.Marshal(map[string]interface{}{"scroll_id": []string{scrollId}})
+	m := sendESRequest(client, "DELETE", body, fmt.Sprintf("%s/_search/scroll", dest), authToken)
+	if succeeded, _ := m["succeeded"].(bool); !succeeded {
+		log.Warnf("failed to clear scroll %s: %s", scrollId, strings.TrimSpace(fmt.Sprintf("%v", m)))
+	}
+}
@@ -0,0 +1,107 @@
+package query
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_sortedAfter(t *testing.T) {
+	cases := []struct {
+		name     string
+		prev     []interface{}
+		next     []interface{}
+		expected bool
+	}{
+		{"older timestamp", []interface{}{2000.0, 5.0}, []interface{}{1000.0, 1.0}, true},
+		{"newer timestamp", []interface{}{1000.0, 1.0}, []interface{}{2000.0, 5.0}, false},
+		{"tie with a later doc", []interface{}{1000.0, 1.0}, []interface{}{1000.0, 2.0}, true},
+		{"tie with an earlier doc", []interface{}{1000.0, 2.0}, []interface{}{1000.0, 1.0}, false},
+		// documents of different shards
+		{"tie with the same doc", []interface{}{1000.0, 2.0}, []interface{}{1000.0, 2.0}, true},
+		{"missing timestamp", []interface{}{nil, 1.0}, []interface{}{1000.0, 2.0}, false},
+		{"invalid doc", []interface{}{1000.0, "a"}, []interface{}{1000.0, "b"}, false},
+	}
+	for _, tc := range cases {
+		assert.Equal(t, tc.expected, sortedAfter(tc.prev, tc.next), tc.name)
+	}
+}
+
+func testHit(id int, ts float64, doc float64, source map[string]interface{}) interface{} {
+	return map[string]interface{}{"_index": "logs", "_id": fmt.Sprintf("%d", id), "sort": []interface{}{ts, doc}, "_source": source}
+}
+
+func testPage(hits ...interface{}) map[string]interface{} {
+	return map[string]interface{}{"hits": map[string]interface{}{"hits": hits}}
+}
+
+func Test_pageChecker(t *testing.T) {
+	cases := []struct {
+		name         string
+		sourceFields []string
+		pages        []map[string]interface{}
+		problems     int
+	}{
+		{
+			name: "continuous pages",
+			pages: []map[string]interface{}{
+				testPage(testHit(1, 3000, 0, nil), testHit(2, 2000, 0, nil), testHit(3, 2000, 4, nil)),
+				testPage(testHit(4, 2000, 7, nil), testHit(5, 1000, 0, nil)),
+				testPage(),
+			},
+		},
+		{
+			name: "next page starts before the previous one",
+			pages: []map[string]interface{}{
+				testPage(testHit(1, 3000, 0, nil), testHit(2, 2000, 0, nil)),
+				testPage(testHit(3, 2500, 0, nil)),
+			},
+			problems: 1,
+		},
+		{
+			name: "repeated document across pages",
+			pages: []map[string]interface{}{
+				testPage(testHit(1, 3000, 0, nil), testHit(2, 2000, 0, nil)),
+				testPage(testHit(2, 2000, 0, nil), testHit(3, 1000, 0, nil)),
+			},
+			problems: 1,
+		},
+		{
+			name: "invalid sort values",
+			pages: []map[string]interface{}{
+				testPage(map[string]interface{}{"_index": "logs", "_id": "1", "sort": []interface{}{3000.0}}),
+			},
+			problems: 1,
+		},
+		{
+			name: "no hits",
+			pages: []map[string]interface{}{
+				{"error": "index not found"},
+			},
+			problems: 1,
+		},
+		{
+			name:         "_source filtering",
+			sourceFields: []string{"timestamp", "city"},
+			pages: []map[string]interface{}{
+				testPage(testHit(1, 3000, 0, map[string]interface{}{"timestamp": 3000.0, "city": "Boston"}),
+					testHit(2, 2000, 0, map[string]interface{}{"timestamp": 2000.0, "city": "Austin", "latency": 1.0})),
+			},
+			problems: 1,
+		},
+	}
+	for _, tc := range cases {
+		pc := newPageChecker(tc.sourceFields)
+		for _, page := range tc.pages {
+			pc.addPage(page)
+		}
+		assert.Len(t, pc.problems, tc.problems, "%s: %v", tc.name, pc.problems)
+	}
+
+	// search_after continues from the sort values of the last hit
+	pc := newPageChecker(nil)
+	numHits, searchAfter := pc.addPage(testPage(testHit(1, 3000, 0, nil), testHit(2, 2000, 3, nil)))
+	assert.Equal(t, 2, numHits)
+	assert.Equal(t, []interface{}{2000.0, 3.0}, searchAfter)
+}
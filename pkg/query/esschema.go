@@ -0,0 +1,345 @@
+package query
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+	"verifier/pkg/utils"
+
+	"github.com/brianvoe/gofakeit/v6"
+	log "github.com/sirupsen/logrus"
+)
+
+type esFieldKind int
+
+const (
+	keywordField esFieldKind = iota
+	textField
+	numericField
+	booleanField
+)
+
+const (
+	// number of documents sampled for field values
+	schemaSampleSize = 500
+	// max number of distinct values kept per field
+	maxFieldValues = 50
+)
+
+// esField is a field that random queries can filter on
+type esField struct {
+	name string
+	kind esFieldKind
+	// field used by term and wildcard queries, e.g. the keyword sub field of a text field.
+	// If empty, term queries use match and there are no wildcard queries
+	termName string
+	// distinct values seen in documents. Numeric values are float64
+	values []interface{}
+	seen   map[string]bool
+}
+
+// ESSchema has the fields of the ingested documents that random queries filter on
+type ESSchema struct {
+	fields []*esField
+	byName map[string]*esField
+}
+
+func newESSchema() *ESSchema {
+	return &ESSchema{byName: make(map[string]*esField)}
+}
+
+func (s *ESSchema) addField(name string, kind esFieldKind, termName string) *esField {
+	f := &esField{name: name, kind: kind, termName: termName, seen: make(map[string]bool)}
+	s.fields = append(s.fields, f)
+	s.byName[name] = f
+	return f
+}
+
+// adds the values of doc to the fields of the schema. If addFields, fields that are not in the schema are added
+// with a kind inferred from their values. Nested objects are flattened with dots, and timestamp and lists are skipped
+func (s *ESSchema) addDocument(doc map[string]interface{}, prefix string, addFields bool) {
+	for k, v := range doc {
+		name := prefix + k
+		if name == "timestamp" {
+			continue
+		}
+		var kind esFieldKind
+		switch value := v.(type) {
+		case map[string]interface{}:
+			s.addDocument(value, name+".", addFields)
+			continue
+		case string:
+			kind = keywordField
+		case bool:
+			kind = booleanField
+		case int:
+			kind, v = numericField, float64(value)
+		case int64:
+			kind, v = numericField, float64(value)
+		case uint64:
+			kind, v = numericField, float64(value)
+		case float64:
+			kind = numericField
+		default:
+			continue
+		}
+		f, ok := s.byName[name]
+		if !ok {
+			if !addFields {
+				continue
+			}
+			f = s.addField(name, kind, name)
+		}
+		f.addValue(v)
+	}
+}
+
+func (f *esField) addValue(v interface{}) {
+	if len(f.values) == maxFieldValues {
+		return
+	}
+	if _, isString := v.(string); (f.kind == keywordField || f.kind == textField) && !isString {
+		return
+	}
+	if _, isNumber := v.(float64); f.kind == numericField && !isNumber {
+		return
+	}
+	key := fmt.Sprintf("%v", v)
+	if f.seen[key] {
+		return
+	}
+	f.seen[key] = true
+	f.values = append(f.values, v)
+}
+
+func (s *ESSchema) sortFields() {
+	sort.Slice(s.fields, func(i, j int) bool { return s.fields[i].name < s.fields[j].name })
+}
+
+// GetESSchema returns the fields random queries filter on. source is the generator the data was ingested with,
+// whose documents are sampled with seed, or "mapping" to discover the fields from the mappings of the searched indices
+// and sample their values from the server. If seed is 0, utils.DefaultSeed is used, like when ingesting without a seed
+func GetESSchema(source string, seed int64, dest string, prefix string, indices string, authToken string) (*ESSchema, error) {
+	if seed == 0 {
+		seed = utils.DefaultSeed
+	}
+	var gen utils.Generator
+	switch source {
+	case "dynamic-user", "benchmark":
+		gen = utils.InitDynamicUserGenerator(false, utils.DeriveSeed(seed, 0))
+	case "static":
+		gen = utils.InitStaticGenerator(false, utils.DeriveSeed(seed, 0))
+	case "k8s":
+		gen = utils.InitK8sGenerator(false, utils.DeriveSeed(seed, 0), seed)
+	case "mapping":
+		return getMappingSchema(http.DefaultClient, dest, getSearchTarget(prefix, indices), authToken)
+	default:
+		return nil, fmt.Errorf("unsupported schema source %s. Options=[dynamic-user,benchmark,static,k8s,mapping]", source)
+	}
+	err := gen.Init()
+	if err != nil {
+		return nil, err
+	}
+	schema := newESSchema()
+	for i := 0; i < schemaSampleSize; i++ {
+		doc, err := gen.GetRawLog()
+		if err != nil {
+			return nil, err
+		}
+		schema.addDocument(doc, "", true)
+	}
+	schema.sortFields()
+	log.Infof("Sampled %d fields from %d documents of the %s generator", len(schema.fields), schemaSampleSize, source)
+	return schema, nil
+}
+
+// gets the fields of target from its mappings and samples their values from the latest documents
+func getMappingSchema(client *http.Client, dest string, target string, authToken string) (*ESSchema, error) {
+	req, err := http.NewRequest("GET", fmt.Sprintf("%s/%s/_mapping", dest, target), nil)
+	if err != nil {
+		return nil, err
+	}
+	if authToken != "" {
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", authToken))
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	rawBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("get mapping returned status %d: %s", resp.StatusCode, rawBody)
+	}
+	indexMappings := make(map[string]map[string]interface{})
+	err = json.Unmarshal(rawBody, &indexMappings)
+	if err != nil {
+		return nil, fmt.Errorf("invalid mapping response: %v", err)
+	}
+
+	schema := newESSchema()
+	for _, index := range indexMappings {
+		mappings, _ := index["mappings"].(map[string]interface{})
+		if props, ok := mappings["properties"].(map[string]interface{}); ok {
+			schema.addMappingProperties(props, "")
+			continue
+		}
+		// mappings of ES 6 and older are nested under the type
+		for _, typeMapping := range mappings {
+			if m, ok := typeMapping.(map[string]interface{}); ok {
+				props, _ := m["properties"].(map[string]interface{})
+				schema.addMappingProperties(props, "")
+			}
+		}
+	}
+	if len(schema.fields) == 0 {
+		return nil, fmt.Errorf("mappings of %s have no fields random queries can filter on", target)
+	}
+	schema.sortFields()
+
+	body, _ := json.Marshal(map[string]interface{}{
+		"size":  schemaSampleSize,
+		"sort":  []interface{}{map[string]interface{}{"timestamp": map[string]interface{}{"order": "desc"}}},
+		"query": map[string]interface{}{"match_all": map[string]interface{}{}},
+	})
+	hits, err := getHitsList(sendESRequest(client, "POST", body, fmt.Sprintf("%s/%s/_search", dest, target), authToken))
+	if err != nil {
+		return nil, fmt.Errorf("failed to sample documents: %v", err)
+	}
+	for _, hit := range hits {
+		if source, ok := hit["_source"].(map[string]interface{}); ok {
+			schema.addDocument(source, "", false)
+		}
+	}
+	log.Infof("Found %d fields in the mappings of %s and sampled values from %d documents", len(schema.fields), target, len(hits))
+	return schema, nil
+}
+
+func (s *ESSchema) addMappingProperties(props map[string]interface{}, prefix string) {
+	for k, raw := range props {
+		name := prefix + k
+		prop, ok := raw.(map[string]interface{})
+		if !ok || name == "timestamp" || s.byName[name] != nil {
+			continue
+		}
+		if sub, ok := prop["properties"].(map[string]interface{}); ok {
+			s.addMappingProperties(sub, name+".")
+			continue
+		}
+		fieldType, _ := prop["type"].(string)
+		switch fieldType {
+		case "keyword", "constant_keyword", "wildcard":
+			s.addField(name, keywordField, name)
+		case "text", "match_only_text":
+			termName := ""
+			subFields, _ := prop["fields"].(map[string]interface{})
+			if kw, ok := subFields["keyword"].(map[string]interface{}); ok && kw["type"] == "keyword" {
+				termName = name + ".keyword"
+			}
+			s.addField(name, textField, termName)
+		case "long", "integer", "short", "byte", "double", "float", "half_float", "scaled_float", "unsigned_long":
+			s.addField(name, numericField, name)
+		case "boolean":
+			s.addField(name, booleanField, name)
+		}
+	}
+}
+
+var randomQueryOccurs = []string{"must", "should", "must_not"}
+
+// returns a query with one to five random conditions over the fields of the schema, in the last day.
+// Conditions are term, range, wildcard and exists queries, sometimes nested in a bool should
+func (s *ESSchema) getRandomQuery(faker *gofakeit.Faker) []byte {
+	time := time.Now().UnixMilli()
+	time1day := time - (1 * 24 * 60 * 60 * 1000)
+
+	clauses := map[string]interface{}{
+		"filter": []interface{}{
+			map[string]interface{}{
+				"range": map[string]interface{}{
+					"timestamp": map[string]interface{}{
+						"gte":    time1day,
+						"lte":    time,
+						"format": "epoch_millis",
+					},
+				},
+			},
+		},
+	}
+	numConditions := faker.Number(1, 5)
+	for i := 0; i < numConditions; i++ {
+		condition := s.getRandomCondition(faker)
+		if faker.Number(0, 4) == 0 {
+			condition = map[string]interface{}{
+				"bool": map[string]interface{}{
+					"should": []interface{}{condition, s.getRandomCondition(faker)},
+				},
+			}
+		}
+		occur := randomQueryOccurs[faker.Number(0, len(randomQueryOccurs)-1)]
+		list, _ := clauses[occur].([]interface{})
+		clauses[occur] = append(list, condition)
+	}
+
+	raw, err := json.Marshal(map[string]interface{}{"query": map[string]interface{}{"bool": clauses}})
+	if err != nil {
+		log.Fatalf("error marshalling query: %+v", err)
+	}
+	return raw
+}
+
+// returns a condition on a random field, chosen among the queries that apply to its kind
+func (s *ESSchema) getRandomCondition(faker *gofakeit.Faker) map[string]interface{} {
+	f := s.fields[faker.Number(0, len(s.fields)-1)]
+	conditions := []func() map[string]interface{}{
+		func() map[string]interface{} {
+			return map[string]interface{}{"exists": map[string]interface{}{"field": f.name}}
+		},
+	}
+	if len(f.values) > 0 {
+		value := func() interface{} { return f.values[faker.Number(0, len(f.values)-1)] }
+		switch {
+		case f.termName != "":
+			conditions = append(conditions, func() map[string]interface{} {
+				return map[string]interface{}{"term": map[string]interface{}{f.termName: value()}}
+			})
+		case f.kind == textField:
+			conditions = append(conditions, func() map[string]interface{} {
+				return map[string]interface{}{"match": map[string]interface{}{f.name: value()}}
+			})
+		}
+		if f.termName != "" && (f.kind == keywordField || f.kind == textField) {
+			conditions = append(conditions, func() map[string]interface{} {
+				return map[string]interface{}{"wildcard": map[string]interface{}{f.termName: getWildcardPattern(faker, value().(string))}}
+			})
+		}
+		if f.kind == numericField {
+			conditions = append(conditions, func() map[string]interface{} {
+				v1, v2 := value().(float64), value().(float64)
+				if v1 > v2 {
+					v1, v2 = v2, v1
+				}
+				return map[string]interface{}{"range": map[string]interface{}{f.name: map[string]interface{}{"gte": v1, "lte": v2}}}
+			})
+		}
+	}
+	return conditions[faker.Number(0, len(conditions)-1)]()
+}
+
+// returns a random prefix of value followed by *
+func getWildcardPattern(faker *gofakeit.Faker, value string) string {
+	runes := []rune(value)
+	prefix := ""
+	if len(runes) > 0 {
+		prefix = string(runes[:faker.Number(1, len(runes))])
+	}
+	replacer := strings.NewReplacer(`\`, `\\`, `*`, `\*`, `?`, `\?`)
+	return replacer.Replace(prefix) + "*"
+}
@@ -0,0 +1,144 @@
+package query
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"verifier/pkg/utils"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_GetESSchemaFromGenerator(t *testing.T) {
+	schema, err := GetESSchema("k8s", 0, "", "", "", "")
+	assert.NoError(t, err)
+
+	// the first worker of an ingestion without a seed sends the sampled documents
+	gen := utils.InitK8sGenerator(false, utils.DeriveSeed(utils.DefaultSeed, 0), utils.DefaultSeed)
+	assert.NoError(t, gen.Init())
+	ingested := make(map[string]map[string]bool)
+	for i := 0; i < schemaSampleSize; i++ {
+		doc, err := gen.GetRawLog()
+		assert.NoError(t, err)
+		addFlattenedValues(ingested, doc, "")
+	}
+
+	kinds := map[string]esFieldKind{
+		"hostname":            keywordField,
+		"kubernetes.pod_name": keywordField,
+		"latency":             numericField,
+		"Port":                numericField,
+	}
+	for name, kind := range kinds {
+		f, ok := schema.byName[name]
+		if !assert.True(t, ok, name) {
+			continue
+		}
+		assert.Equal(t, kind, f.kind, name)
+		assert.Equal(t, name, f.termName, name)
+		assert.NotEmpty(t, f.values, name)
+		assert.LessOrEqual(t, len(f.values), maxFieldValues, name)
+	}
+	for _, f := range schema.fields {
+		assert.NotEqual(t, "timestamp", f.name)
+		for _, v := range f.values {
+			assert.True(t, ingested[f.name][fmt.Sprintf("%v", v)], "%s=%v was not ingested", f.name, v)
+		}
+	}
+	for i := 1; i < len(schema.fields); i++ {
+		assert.Less(t, schema.fields[i-1].name, schema.fields[i].name)
+	}
+
+	_, err = GetESSchema("syslog", 0, "", "", "", "")
+	assert.Error(t, err)
+}
+
+func addFlattenedValues(values map[string]map[string]bool, doc map[string]interface{}, prefix string) {
+	for k, v := range doc {
+		if nested, ok := v.(map[string]interface{}); ok {
+			addFlattenedValues(values, nested, prefix+k+".")
+			continue
+		}
+		if values[prefix+k] == nil {
+			values[prefix+k] = make(map[string]bool)
+		}
+		values[prefix+k][fmt.Sprintf("%v", v)] = true
+	}
+}
+
+const testMapping = `{
+  "logs-1": {"mappings": {"properties": {
+    "timestamp": {"type": "date"},
+    "city": {"type": "keyword"},
+    "msg": {"type": "text", "fields": {"keyword": {"type": "keyword", "ignore_above": 256}}},
+    "question": {"type": "text"},
+    "latency": {"type": "long"},
+    "kubernetes": {"properties": {"pod_name": {"type": "keyword"}}},
+    "location": {"type": "geo_point"}
+  }}},
+  "logs-2": {"mappings": {"_doc": {"properties": {
+    "city": {"type": "keyword"},
+    "secure": {"type": "boolean"}
+  }}}}
+}`
+
+const testSampleHits = `{"hits": {"hits": [
+  {"_source": {"timestamp": 1, "city": "Boston", "msg": "started", "latency": 10, "kubernetes": {"pod_name": "api-0"}, "secure": true, "extra": "x"}},
+  {"_source": {"city": "Boston", "msg": "stopped", "latency": "slow", "question": "why?"}},
+  {"_source": {"city": 7}}
+]}}`
+
+func Test_getMappingSchema(t *testing.T) {
+	requests := make([]string, 0)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.Method+" "+r.URL.Path)
+		assert.Equal(t, "Bearer token", r.Header.Get("Authorization"))
+		switch r.URL.Path {
+		case "/logs-*/_mapping":
+			fmt.Fprint(w, testMapping)
+		case "/logs-*/_search":
+			body, err := ioutil.ReadAll(r.Body)
+			assert.NoError(t, err)
+			assert.Contains(t, string(body), `"size":500`)
+			fmt.Fprint(w, testSampleHits)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	schema, err := getMappingSchema(server.Client(), server.URL, getSearchTarget("logs-", ""), "token")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"GET /logs-*/_mapping", "POST /logs-*/_search"}, requests)
+
+	cases := []struct {
+		name     string
+		kind     esFieldKind
+		termName string
+		values   []interface{}
+	}{
+		{"city", keywordField, "city", []interface{}{"Boston"}},
+		{"kubernetes.pod_name", keywordField, "kubernetes.pod_name", []interface{}{"api-0"}},
+		{"latency", numericField, "latency", []interface{}{10.0}},
+		{"msg", textField, "msg.keyword", []interface{}{"started", "stopped"}},
+		{"question", textField, "", []interface{}{"why?"}},
+		{"secure", booleanField, "secure", []interface{}{true}},
+	}
+	// fields are sorted by name, and fields that are not in the mappings get no values
+	assert.Len(t, schema.fields, len(cases))
+	for i, tc := range cases {
+		if !assert.Less(t, i, len(schema.fields), tc.name) {
+			continue
+		}
+		f := schema.fields[i]
+		assert.Equal(t, tc.name, f.name)
+		assert.Equal(t, tc.kind, f.kind, tc.name)
+		assert.Equal(t, tc.termName, f.termName, tc.name)
+		assert.Equal(t, tc.values, f.values, tc.name)
+	}
+
+	_, err = getMappingSchema(server.Client(), server.URL, "missing", "token")
+	assert.Error(t, err)
+}
@@ -1,12 +1,14 @@
 package query
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"math/rand"
 	"net/http"
 	"net/url"
+	"strings"
 	"time"
 
 	"github.com/montanaflynn/stats"
@@ -18,120 +20,387 @@ type metricsQueryTypes int
 const (
 	simpleKeyValueQuery metricsQueryTypes = iota
 	wildcardKey
+	rateQuery
+	counterRateQuery
+	downsample1mZeroFill
+	downsample1hNullFill
+	downsampleAllQuery
+	literalOrFilter
+	notLiteralOrFilter
+	wildcardFilter
+	regexpFilter
+	multiMetricQuery
+	explicitEndQuery
+	postJSONQuery
 )
 
+var allMetricsQueryTypes = []metricsQueryTypes{simpleKeyValueQuery, wildcardKey, rateQuery, counterRateQuery,
+	downsample1mZeroFill, downsample1hNullFill, downsampleAllQuery, literalOrFilter, notLiteralOrFilter, wildcardFilter,
+	regexpFilter, multiMetricQuery, explicitEndQuery, postJSONQuery}
+
 var aggFns = [...]string{"avg", "min", "max", "sum"}
 
+// tag key and values used by the filters of the query suite. These are random tags sent by the metrics generator.
+// When validating values, tags of the schema are used instead
+var queryTagKey = "color"
+var queryTagValues = []string{"yellow", "red", "blue"}
+
 func (m metricsQueryTypes) String() string {
 	switch m {
 	case simpleKeyValueQuery:
 		return "simple key=value"
 	case wildcardKey:
 		return "simple key=*"
+	case rateQuery:
+		return "rate"
+	case counterRateQuery:
+		return "counter rate"
+	case downsample1mZeroFill:
+		return "downsample 1m fill zero"
+	case downsample1hNullFill:
+		return "downsample 1h fill null"
+	case downsampleAllQuery:
+		return "downsample all"
+	case literalOrFilter:
+		return "literal_or filter"
+	case notLiteralOrFilter:
+		return "not_literal_or filter"
+	case wildcardFilter:
+		return "wildcard filter"
+	case regexpFilter:
+		return "regexp filter"
+	case multiMetricQuery:
+		return "multiple metrics"
+	case explicitEndQuery:
+		return "explicit end"
+	case postJSONQuery:
+		return "POST json"
 	default:
 		return "UNKNOWN"
 	}
 }
 
-func getSimpleMetricsQuery(url *url.URL) string {
-	values := url.Query()
-	values.Set("start", "1d-ago")
-	aggFn := aggFns[rand.Intn(len(aggFns))]
-	values.Set("m", fmt.Sprintf("%s:3h-%s:testmetric0{color=\"yellow\"}", aggFn, aggFn))
-	url.RawQuery = values.Encode()
-	str := url.String()
-	log.Errorf("final url is %+v", str)
-	return str
+// otsdbQuery is a single request of the query suite. If body is set, it is POSTed to url
+type otsdbQuery struct {
+	url     string
+	body    []byte
+	request *otsdbQueryRequest
+	// expected series of each sub query. Only set if values are validated
+	members []subQueryMembers
 }
 
-func getWildcardMetricsQuery(url *url.URL) string {
-	values := url.Query()
-	values.Set("start", "1d-ago")
-	aggFn := aggFns[rand.Intn(len(aggFns))]
-	values.Set("m", fmt.Sprintf("%s:3h-%s:testmetric0{color=*}", aggFn, aggFn))
-	url.RawQuery = values.Encode()
-	str := url.String()
-	log.Errorf("final url is %+v", str)
-	return str
+// otsdbFilter is a filter of the POST json query form
+type otsdbFilter struct {
+	Type    string `json:"type"`
+	Tagk    string `json:"tagk"`
+	Filter  string `json:"filter"`
+	GroupBy bool   `json:"groupBy"`
+}
+
+type otsdbSubQuery struct {
+	Aggregator  string                 `json:"aggregator"`
+	Metric      string                 `json:"metric"`
+	Downsample  string                 `json:"downsample,omitempty"`
+	Rate        bool                   `json:"rate,omitempty"`
+	RateOptions map[string]interface{} `json:"rateOptions,omitempty"`
+	Filters     []otsdbFilter          `json:"filters,omitempty"`
+}
+
+type otsdbQueryRequest struct {
+	Start   string          `json:"start"`
+	End     string          `json:"end,omitempty"`
+	Queries []otsdbSubQuery `json:"queries"`
+}
+
+// returns the query of mqType. aggFn is used by all sub queries and the filters use tagKey and tagValues
+func getMetricsQuery(mqType metricsQueryTypes, reqStr string, aggFn string, tagKey string, tagValues []string) (*otsdbQuery, error) {
+	tagValue := tagValues[0]
+	request := &otsdbQueryRequest{Start: "1d-ago"}
+	subQuery := otsdbSubQuery{Aggregator: aggFn, Metric: "testmetric0"}
+	switch mqType {
+	case simpleKeyValueQuery:
+		subQuery.Downsample = fmt.Sprintf("3h-%s", aggFn)
+		subQuery.Filters = []otsdbFilter{{Type: "literal_or", Tagk: tagKey, Filter: tagValue, GroupBy: true}}
+	case wildcardKey:
+		subQuery.Downsample = fmt.Sprintf("3h-%s", aggFn)
+		subQuery.Filters = []otsdbFilter{{Type: "wildcard", Tagk: tagKey, Filter: "*", GroupBy: true}}
+	case rateQuery:
+		subQuery.Downsample = "1m-avg"
+		subQuery.Rate = true
+	case counterRateQuery:
+		subQuery.Downsample = "1m-avg"
+		subQuery.Rate = true
+		subQuery.RateOptions = map[string]interface{}{"counter": true}
+	case downsample1mZeroFill:
+		request.Start = "1h-ago"
+		subQuery.Downsample = fmt.Sprintf("1m-%s-zero", aggFn)
+	case downsample1hNullFill:
+		subQuery.Downsample = fmt.Sprintf("1h-%s-null", aggFn)
+	case downsampleAllQuery:
+		subQuery.Downsample = fmt.Sprintf("0all-%s", aggFn)
+	case literalOrFilter:
+		subQuery.Filters = []otsdbFilter{{Type: "literal_or", Tagk: tagKey, Filter: strings.Join(tagValues, "|"), GroupBy: true}}
+	case notLiteralOrFilter:
+		subQuery.Filters = []otsdbFilter{{Type: "not_literal_or", Tagk: tagKey, Filter: strings.Join(tagValues, "|"), GroupBy: true}}
+	case wildcardFilter:
+		subQuery.Filters = []otsdbFilter{{Type: "wildcard", Tagk: tagKey, Filter: getWildcard(tagValue), GroupBy: true}}
+	case regexpFilter:
+		subQuery.Filters = []otsdbFilter{{Type: "regexp", Tagk: tagKey, Filter: fmt.Sprintf("^%s.*", tagValue[:1]), GroupBy: true}}
+	case multiMetricQuery:
+		for i := 0; i < 3; i++ {
+			request.Queries = append(request.Queries, otsdbSubQuery{
+				Aggregator: aggFn,
+				Metric:     fmt.Sprintf("testmetric%d", i),
+				Downsample: fmt.Sprintf("5m-%s", aggFn),
+			})
+		}
+	case explicitEndQuery:
+		request.Start = "2h-ago"
+		request.End = "1h-ago"
+		subQuery.Downsample = fmt.Sprintf("1m-%s", aggFn)
+		subQuery.Filters = []otsdbFilter{{Type: "wildcard", Tagk: tagKey, Filter: "*", GroupBy: true}}
+	case postJSONQuery:
+		request.Start = "1h-ago"
+		subQuery.Downsample = fmt.Sprintf("5m-%s", aggFn)
+		subQuery.Filters = []otsdbFilter{{Type: "literal_or", Tagk: tagKey, Filter: strings.Join(tagValues[:2], "|"), GroupBy: true}}
+		request.Queries = append(request.Queries, subQuery, otsdbSubQuery{
+			Aggregator:  aggFn,
+			Metric:      "testmetric1",
+			Rate:        true,
+			RateOptions: map[string]interface{}{"counter": true, "dropResets": true},
+		})
+		body, err := json.Marshal(request)
+		if err != nil {
+			return nil, err
+		}
+		return &otsdbQuery{url: reqStr, body: body, request: request}, nil
+	default:
+		return nil, fmt.Errorf("unsupported query type %v", mqType)
+	}
+	if len(request.Queries) == 0 {
+		request.Queries = append(request.Queries, subQuery)
+	}
+
+	baseUrl, err := url.Parse(reqStr)
+	if err != nil {
+		return nil, err
+	}
+	values := baseUrl.Query()
+	values.Set("start", request.Start)
+	if request.End != "" {
+		values.Set("end", request.End)
+	}
+	for _, sq := range request.Queries {
+		values.Add("m", sq.mParam())
+	}
+	baseUrl.RawQuery = values.Encode()
+	return &otsdbQuery{url: baseUrl.String(), request: request}, nil
+}
+
+// returns a wildcard that matches tagValue and not every value of its tag. Values of the tag schema, e.g. color-0, are
+// matched by their numeric suffix, since their key prefix is shared by all values
+func getWildcard(tagValue string) string {
+	if i := strings.LastIndex(tagValue, "-"); i >= 0 {
+		return "*" + tagValue[i:]
+	}
+	return fmt.Sprintf("*%s*", tagValue[1:len(tagValue)-1])
+}
+
+// returns the m parameter of the GET query form, e.g. sum:1m-avg:rate:testmetric0{color=*}
+func (sq otsdbSubQuery) mParam() string {
+	parts := []string{sq.Aggregator}
+	if sq.Downsample != "" {
+		parts = append(parts, sq.Downsample)
+	}
+	if sq.Rate {
+		if counter, _ := sq.RateOptions["counter"].(bool); counter {
+			parts = append(parts, "rate{counter}")
+		} else {
+			parts = append(parts, "rate")
+		}
+	}
+	groupBy := make([]string, 0)
+	others := make([]string, 0)
+	for _, f := range sq.Filters {
+		var expr string
+		switch {
+		case f.Type == "literal_or" && !strings.Contains(f.Filter, "|"):
+			// same form as the simple key=value query has always sent, e.g. testmetric0{color="yellow"}
+			expr = fmt.Sprintf("%s=\"%s\"", f.Tagk, f.Filter)
+		case f.Type == "wildcard" && f.Filter == "*":
+			expr = fmt.Sprintf("%s=*", f.Tagk)
+		default:
+			expr = fmt.Sprintf("%s=%s(%s)", f.Tagk, f.Type, f.Filter)
+		}
+		if f.GroupBy {
+			groupBy = append(groupBy, expr)
+		} else {
+			others = append(others, expr)
+		}
+	}
+	metric := sq.Metric
+	if len(groupBy) > 0 || len(others) > 0 {
+		metric += "{" + strings.Join(groupBy, ",") + "}"
+	}
+	if len(others) > 0 {
+		metric += "{" + strings.Join(others, ",") + "}"
+	}
+	return strings.Join(append(parts, metric), ":")
+}
+
+// otsdbError is the body OTSDB returns for a failed query
+type otsdbError struct {
+	Error struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+		Details string `json:"details"`
+	} `json:"error"`
 }
 
-// Returns elapsed time. If verbose, logs the number of returned series
-func sendSingleOTSDBRequest(client *http.Client, mqType metricsQueryTypes, url string, verbose bool) (float64, int) {
-	req, err := http.NewRequest("GET", url, nil)
+// Returns elapsed time and the returned series. Returns an error if the request fails or the response is not a
+// list of series, including the message of OTSDB error bodies
+func sendSingleOTSDBRequest(client *http.Client, mqType metricsQueryTypes, query *otsdbQuery, verbose bool) (float64, []otsdbResult, error) {
+	var req *http.Request
+	var err error
+	if query.body != nil {
+		req, err = http.NewRequest("POST", query.url, bytes.NewReader(query.body))
+		if err == nil {
+			req.Header.Set("Content-Type", "application/json")
+		}
+	} else {
+		req, err = http.NewRequest("GET", query.url, nil)
+	}
 	if err != nil {
-		log.Fatalf("sendRequest: http.NewRequest ERROR: %v", err)
+		return 0, nil, fmt.Errorf("failed to create request: %w", err)
 	}
 
 	stime := time.Now()
 	resp, err := client.Do(req)
 	if err != nil {
-		log.Fatalf("sendRequest: client.Do ERROR: %v", err)
+		return 0, nil, fmt.Errorf("failed to send request: %w", err)
 	}
 	defer resp.Body.Close()
 	rawBody, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
-		log.Fatalf("sendRequest: client.Do ERROR: %v", err)
+		return 0, nil, fmt.Errorf("failed to read response: %w", err)
 	}
-	m := make([]interface{}, 0)
+	elapsed := time.Since(stime)
+	m := make([]otsdbResult, 0)
 	err = json.Unmarshal(rawBody, &m)
-	if err != nil {
-		log.Fatalf("sendRequest: response unmarshal ERROR: %v", err)
+	if resp.StatusCode/100 != 2 || err != nil {
+		return 0, nil, parseOTSDBError(resp.StatusCode, rawBody)
 	}
-	log.Infof("returned response: %v in %+v. Num series=%+v", mqType, time.Since(stime), len(m))
-	return float64(time.Since(stime).Milliseconds()), len(m)
+	log.Infof("returned response: %v in %+v. Num series=%+v", mqType, elapsed, len(m))
+	if verbose {
+		log.Infof("%v response: %s", mqType, rawBody)
+	}
+	return float64(elapsed.Milliseconds()), m, nil
 }
 
-// returns a map of qtype to list of result query times and a map of qType to the raw url to send requests to
-func initMetricsResultMap(numIterations int, reqStr string) (map[metricsQueryTypes][]float64, map[metricsQueryTypes]string) {
-	results := make(map[metricsQueryTypes][]float64)
-	rawUrl := make(map[metricsQueryTypes]string)
+// max length of a response body included in an error
+const maxErrorBodyLen = 200
 
-	baseUrl, err := url.Parse(reqStr)
-	if err != nil {
-		log.Fatalf("Failed to parse url! Error %+v", err)
+func parseOTSDBError(statusCode int, rawBody []byte) error {
+	oErr := otsdbError{}
+	if json.Unmarshal(rawBody, &oErr) == nil && oErr.Error.Message != "" {
+		if oErr.Error.Details != "" {
+			return fmt.Errorf("status %d: %s. %s", oErr.Error.Code, oErr.Error.Message, oErr.Error.Details)
+		}
+		return fmt.Errorf("status %d: %s", oErr.Error.Code, oErr.Error.Message)
+	}
+	body := string(rawBody)
+	if len(body) > maxErrorBodyLen {
+		body = body[:maxErrorBodyLen] + "..."
 	}
-	rawSimpleURL := getSimpleMetricsQuery(baseUrl)
-	rawUrl[simpleKeyValueQuery] = rawSimpleURL
-	results[simpleKeyValueQuery] = make([]float64, numIterations)
+	return fmt.Errorf("status %d: response is not a list of series: %s", statusCode, body)
+}
 
-	baseUrl, err = url.Parse(reqStr)
-	if err != nil {
-		log.Fatalf("Failed to parse url! Error %+v", err)
+// returns a map of qtype to list of result query times and a map of qType to the query to send. If validation is set,
+// the expected series of every query are listed once here instead of on every iteration
+func initMetricsResultMap(numIterations int, reqStr string, tagKey string, tagValues []string, validation *MetricsValidation) (map[metricsQueryTypes][]float64, map[metricsQueryTypes]*otsdbQuery) {
+	results := make(map[metricsQueryTypes][]float64)
+	queries := make(map[metricsQueryTypes]*otsdbQuery)
+
+	aggFn := aggFns[rand.Intn(len(aggFns))]
+	for _, qType := range allMetricsQueryTypes {
+		query, err := getMetricsQuery(qType, reqStr, aggFn, tagKey, tagValues)
+		if err != nil {
+			log.Fatalf("Failed to create %v query! Error %+v", qType, err)
+		}
+		if query.body != nil {
+			log.Infof("%v query: POST %s %s", qType, query.url, query.body)
+		} else {
+			log.Infof("%v query: GET %s", qType, query.url)
+		}
+		if validation != nil {
+			for _, sq := range query.request.Queries {
+				query.members = append(query.members, validation.subQueryMembers(sq))
+			}
+		}
+		queries[qType] = query
+		results[qType] = make([]float64, 0, numIterations)
 	}
-	rawWildcardURL := getWildcardMetricsQuery(baseUrl)
-	rawUrl[wildcardKey] = rawWildcardURL
-	results[wildcardKey] = make([]float64, numIterations)
-	return results, rawUrl
+	return results, queries
 }
 
-func StartMetricsQuery(dest string, numIterations int, continuous, verbose, validateMetricsOutput bool) map[string]bool {
+// Each request times out after timeout. Failed requests are counted per query type instead of stopping the suite.
+// If validation is set, the values of every query are checked against the ingested series it describes
+func StartMetricsQuery(dest string, numIterations int, continuous, verbose, validateMetricsOutput bool, timeout time.Duration, validation *MetricsValidation) map[string]bool {
 	rand.Seed(time.Now().UnixNano())
-	client := http.DefaultClient
+	client := &http.Client{Timeout: timeout}
 	if numIterations == 0 && !continuous {
 		log.Fatalf("Iterations must be greater than 0")
 	}
+	tagKey, tagValues := queryTagKey, queryTagValues
+	if validation != nil {
+		var err error
+		tagKey, tagValues, err = validation.queryTags()
+		if err != nil {
+			log.Fatalf("Invalid metrics validation options: %+v", err)
+		}
+	}
 	validResult := make(map[string]bool)
 	requestStr := fmt.Sprintf("%s/api/query", dest)
-	results, queries := initMetricsResultMap(numIterations, requestStr)
+	results, queries := initMetricsResultMap(numIterations, requestStr, tagKey, tagValues, validation)
+	numSent := make(map[metricsQueryTypes]int)
+	numErrors := make(map[metricsQueryTypes]int)
 	for i := 0; i < numIterations || continuous; i++ {
-		for qType, query := range queries {
-			time, numTS := sendSingleOTSDBRequest(client, qType, query, verbose)
+		for _, qType := range allMetricsQueryTypes {
+			numSent[qType]++
+			time, series, err := sendSingleOTSDBRequest(client, qType, queries[qType], verbose)
+			if err != nil {
+				numErrors[qType]++
+				log.Errorf("%v query failed: %v", qType, err)
+				if validateMetricsOutput {
+					validResult[qType.String()] = false
+				}
+				continue
+			}
 			if !continuous {
-				results[qType][i] = time
+				results[qType] = append(results[qType], time)
 			}
-			if validateMetricsOutput && numTS == 0 {
+			if validateMetricsOutput && len(series) == 0 {
+				validResult[qType.String()] = false
+			}
+			if validateMetricsOutput && validation != nil && !validation.check(qType, queries[qType], series) {
 				validResult[qType.String()] = false
 			}
 		}
 	}
 
 	log.Infof("-----Query Summary. Completed %d iterations----", numIterations)
-	for qType, qRes := range results {
+	for _, qType := range allMetricsQueryTypes {
+		qRes := results[qType]
 		p95, _ := stats.Percentile(qRes, 95)
 		avg, _ := stats.Mean(qRes)
 		max, _ := stats.Max(qRes)
 		min, _ := stats.Min(qRes)
-		log.Infof("QueryType: %s. Min:%+vms, Max:%+vms, Avg:%+vms, P95:%+vms", qType.String(), min, max, avg, p95)
+		errorRate := 0.0
+		if numSent[qType] > 0 {
+			errorRate = 100 * float64(numErrors[qType]) / float64(numSent[qType])
+		}
+		log.Infof("QueryType: %s. Min:%+vms, Max:%+vms, Avg:%+vms, P95:%+vms, Errors:%d/%d (%.2f%%)", qType.String(), min, max, avg, p95,
+			numErrors[qType], numSent[qType], errorRate)
 	}
 	return validResult
 }
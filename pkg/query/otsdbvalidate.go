@@ -0,0 +1,420 @@
+package query
+
+import (
+	"fmt"
+	"math"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"verifier/pkg/utils"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// MetricsValidation describes the series ingested by `ingest metrics --backfill`, so the values returned by the
+// query suite can be recomputed. Cfg must have the tag schema, number of metrics and value model used to ingest
+type MetricsValidation struct {
+	Cfg *utils.MetricsConfig
+	// scrape interval used to ingest
+	Interval time.Duration
+	// max difference between a returned and an expected value, relative to the expected value
+	Tolerance float64
+}
+
+// otsdbResult is a single series of a query response. Null values of null filled buckets are nil
+type otsdbResult struct {
+	Metric        string              `json:"metric"`
+	Tags          map[string]string   `json:"tags"`
+	AggregateTags []string            `json:"aggregateTags"`
+	Dps           map[string]*float64 `json:"dps"`
+}
+
+// max number of mismatches logged for a query
+const maxLoggedMismatches = 5
+
+// returns the tag key and values used by the filters of the query suite
+func (mv *MetricsValidation) queryTags() (string, []string, error) {
+	if len(mv.Cfg.Tags) == 0 {
+		return "", nil, fmt.Errorf("validating values requires a tag schema")
+	}
+	if mv.Interval < time.Second || mv.Interval%time.Second != 0 {
+		return "", nil, fmt.Errorf("interval must be a whole number of seconds, got %+v", mv.Interval)
+	}
+	// at least one value is left out, so not_literal_or filters return series
+	tag := mv.Cfg.Tags[0]
+	if tag.Cardinality < 3 {
+		return "", nil, fmt.Errorf("tag %s needs at least 3 values to be used in filters", tag.Key)
+	}
+	values := make([]string, 0, 3)
+	for i := uint64(0); i < tag.Cardinality-1 && i < 3; i++ {
+		values = append(values, fmt.Sprintf("%s-%d", tag.Key, i))
+	}
+	return tag.Key, values, nil
+}
+
+// subQueryMembers are the ingested series that a sub query reads, or the reason they cannot be listed
+type subQueryMembers struct {
+	tags []map[string]interface{}
+	err  error
+}
+
+// returns the series of the metric of sq that match its filters
+func (mv *MetricsValidation) subQueryMembers(sq otsdbSubQuery) subQueryMembers {
+	members, err := mv.Cfg.SeriesOf(sq.Metric)
+	if err != nil {
+		return subQueryMembers{err: err}
+	}
+	for _, f := range sq.Filters {
+		members, err = filterSeries(members, f)
+		if err != nil {
+			return subQueryMembers{err: err}
+		}
+	}
+	return subQueryMembers{tags: members}
+}
+
+// check compares the series returned for query with the expected ones and logs the mismatches.
+// Returns false if any point does not match
+func (mv *MetricsValidation) check(qType metricsQueryTypes, query *otsdbQuery, series []otsdbResult) bool {
+	checked := 0
+	mismatches := make([]string, 0)
+	for i, sq := range query.request.Queries {
+		returned := make([]otsdbResult, 0)
+		for _, s := range series {
+			if s.Metric == sq.Metric {
+				returned = append(returned, s)
+			}
+		}
+		members := query.members[i]
+		if members.err != nil {
+			log.Infof("not validating values of %v query for %s: %v", qType, sq.Metric, members.err)
+			continue
+		}
+		numChecked, subMismatches, err := mv.checkSubQuery(sq, members.tags, returned)
+		if err != nil {
+			log.Infof("not validating values of %v query for %s: %v", qType, sq.Metric, err)
+			continue
+		}
+		checked += numChecked
+		mismatches = append(mismatches, subMismatches...)
+	}
+	for i, m := range mismatches {
+		if i == maxLoggedMismatches {
+			log.Errorf("%v query: %d more mismatches", qType, len(mismatches)-maxLoggedMismatches)
+			break
+		}
+		log.Errorf("%v query: %s", qType, m)
+	}
+	log.Infof("validated values of %v query. Points checked=%d, mismatches=%d", qType, checked, len(mismatches))
+	return len(mismatches) == 0
+}
+
+// returns the number of checked points and a description of every mismatch. members are the series that sq reads.
+// Points that depend on where the ingested range starts or ends are skipped: the first and last downsampled buckets
+// with data, the filled buckets outside of them and anything downsampled over the whole range
+func (mv *MetricsValidation) checkSubQuery(sq otsdbSubQuery, members []map[string]interface{}, returned []otsdbResult) (int, []string, error) {
+	ds, err := parseDownsample(sq.Downsample)
+	if err != nil {
+		return 0, nil, err
+	}
+	rate, err := parseRateOptions(sq)
+	if err != nil {
+		return 0, nil, err
+	}
+	if _, ok := aggregate(sq.Aggregator, []float64{0}); !ok {
+		return 0, nil, fmt.Errorf("unsupported aggregator %s", sq.Aggregator)
+	}
+
+	groupKeys := make([]string, 0)
+	for _, f := range sq.Filters {
+		if f.GroupBy {
+			groupKeys = append(groupKeys, f.Tagk)
+		}
+	}
+	groups := make(map[string][]map[string]interface{})
+	for _, tags := range members {
+		key := groupKey(groupKeys, func(k string) string { return fmt.Sprintf("%v", tags[k]) })
+		groups[key] = append(groups[key], tags)
+	}
+
+	mismatches := make([]string, 0)
+	if len(returned) != len(groups) {
+		mismatches = append(mismatches, fmt.Sprintf("%s: expected %d series, got %d", sq.Metric, len(groups), len(returned)))
+	}
+	checked := 0
+	for _, r := range returned {
+		key := groupKey(groupKeys, func(k string) string { return r.Tags[k] })
+		group, ok := groups[key]
+		if !ok {
+			mismatches = append(mismatches, fmt.Sprintf("%s%v: unexpected series", sq.Metric, r.Tags))
+			continue
+		}
+		numChecked, seriesMismatches := mv.checkSeries(sq, ds, rate, group, r)
+		checked += numChecked
+		mismatches = append(mismatches, seriesMismatches...)
+	}
+	return checked, mismatches, nil
+}
+
+func (mv *MetricsValidation) checkSeries(sq otsdbSubQuery, ds downsampleSpec, rate rateSpec, group []map[string]interface{}, r otsdbResult) (int, []string) {
+	interval := int64(mv.Interval.Seconds())
+	step := interval
+	if ds.interval > 0 {
+		step = ds.interval
+	}
+	timestamps := make([]int64, 0, len(r.Dps))
+	for k := range r.Dps {
+		ts, err := strconv.ParseInt(k, 10, 64)
+		if err != nil {
+			return 0, []string{fmt.Sprintf("%s%v: invalid timestamp %s", sq.Metric, r.Tags, k)}
+		}
+		timestamps = append(timestamps, ts)
+	}
+	sort.Slice(timestamps, func(i, j int) bool { return timestamps[i] < timestamps[j] })
+
+	// buckets before the first and after the last one with data are filled because nothing was ingested there
+	first, last := 0, len(timestamps)-1
+	if ds.fill != "" {
+		for first <= last && isFilled(ds, r.Dps[strconv.FormatInt(timestamps[first], 10)]) {
+			first++
+		}
+		for last >= first && isFilled(ds, r.Dps[strconv.FormatInt(timestamps[last], 10)]) {
+			last--
+		}
+	}
+
+	checked := 0
+	mismatches := make([]string, 0)
+	for i, ts := range timestamps {
+		if ts%step != 0 {
+			mismatches = append(mismatches, fmt.Sprintf("%s%v: timestamp %d is not a multiple of %ds", sq.Metric, r.Tags, ts, step))
+			continue
+		}
+		if i > 0 && ts-timestamps[i-1] != step && !rate.dropResets {
+			mismatches = append(mismatches, fmt.Sprintf("%s%v: missing points between %d and %d", sq.Metric, r.Tags, timestamps[i-1], ts))
+		}
+		// edge buckets only cover part of the ingested range. A rate also needs the previous bucket
+		if i < first || i > last || (ds.interval > 0 && (i == first || i == last || (rate.enabled && i == first+1))) {
+			continue
+		}
+		// every bucket between the edges has ingested points, so a null is a missing bucket whatever the fill.
+		// A zero is compared with the expected value
+		got := r.Dps[strconv.FormatInt(ts, 10)]
+		if got == nil {
+			mismatches = append(mismatches, fmt.Sprintf("%s%v: missing bucket at %d", sq.Metric, r.Tags, ts))
+			continue
+		}
+
+		values := make([]float64, 0, len(group))
+		skip := false
+		for _, tags := range group {
+			v, ok := mv.pointValue(sq.Metric, tags, ts, interval, ds, rate, step)
+			if !ok {
+				skip = true
+				break
+			}
+			values = append(values, v)
+		}
+		if skip {
+			continue
+		}
+		expected, _ := aggregate(sq.Aggregator, values)
+		checked++
+		if math.Abs(*got-expected) > mv.Tolerance*math.Max(math.Abs(expected), 1) {
+			mismatches = append(mismatches, fmt.Sprintf("%s%v at %d: expected %v, got %v", sq.Metric, r.Tags, ts, expected, *got))
+		}
+	}
+	return checked, mismatches
+}
+
+// returns true if v is the value that the fill policy of ds gives to buckets without points
+func isFilled(ds downsampleSpec, v *float64) bool {
+	return v == nil || (ds.fill == "zero" && *v == 0)
+}
+
+// returns the value of a single series at ts after downsampling and rate. Returns false if the point is dropped
+func (mv *MetricsValidation) pointValue(metric string, tags map[string]interface{}, ts, interval int64, ds downsampleSpec, rate rateSpec, step int64) (float64, bool) {
+	cur := mv.bucketValue(metric, tags, ts, interval, ds)
+	if !rate.enabled {
+		return cur, true
+	}
+	prev := mv.bucketValue(metric, tags, ts-step, interval, ds)
+	if rate.counter && cur < prev {
+		if rate.dropResets {
+			return 0, false
+		}
+		r := (rate.counterMax - prev + cur) / float64(step)
+		if rate.resetValue > 0 && r > rate.resetValue {
+			return 0, true
+		}
+		return r, true
+	}
+	return (cur - prev) / float64(step), true
+}
+
+func (mv *MetricsValidation) bucketValue(metric string, tags map[string]interface{}, ts, interval int64, ds downsampleSpec) float64 {
+	if ds.interval == 0 {
+		v, _ := mv.Cfg.Value(metric, tags, ts)
+		return v
+	}
+	values := make([]float64, 0, ds.interval/interval+1)
+	first := (ts + interval - 1) / interval * interval
+	for t := first; t < ts+ds.interval; t += interval {
+		v, _ := mv.Cfg.Value(metric, tags, t)
+		values = append(values, v)
+	}
+	v, _ := aggregate(ds.fn, values)
+	return v
+}
+
+type downsampleSpec struct {
+	// bucket width in seconds, 0 if not downsampled
+	interval int64
+	fn       string
+	fill     string
+}
+
+// parses downsample specs like 1m-avg and 1h-sum-null
+func parseDownsample(spec string) (downsampleSpec, error) {
+	if spec == "" {
+		return downsampleSpec{}, nil
+	}
+	parts := strings.Split(spec, "-")
+	if len(parts) < 2 {
+		return downsampleSpec{}, fmt.Errorf("invalid downsample %s", spec)
+	}
+	if strings.HasPrefix(parts[0], "0all") {
+		return downsampleSpec{}, fmt.Errorf("downsampling over the whole range depends on the ingested range")
+	}
+	interval, err := parseOTSDBDuration(parts[0])
+	if err != nil {
+		return downsampleSpec{}, err
+	}
+	if _, ok := aggregate(parts[1], []float64{0}); !ok {
+		return downsampleSpec{}, fmt.Errorf("unsupported downsample function %s", parts[1])
+	}
+	ds := downsampleSpec{interval: interval, fn: parts[1]}
+	if len(parts) > 2 {
+		ds.fill = parts[2]
+	}
+	return ds, nil
+}
+
+// parses durations like 30s, 5m, 3h and 1d into seconds
+func parseOTSDBDuration(d string) (int64, error) {
+	units := map[string]int64{"s": 1, "m": 60, "h": 3600, "d": 86400, "w": 7 * 86400}
+	if len(d) < 2 {
+		return 0, fmt.Errorf("invalid duration %s", d)
+	}
+	unit, ok := units[d[len(d)-1:]]
+	n, err := strconv.ParseInt(d[:len(d)-1], 10, 64)
+	if !ok || err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid duration %s", d)
+	}
+	return n * unit, nil
+}
+
+type rateSpec struct {
+	enabled    bool
+	counter    bool
+	dropResets bool
+	counterMax float64
+	resetValue float64
+}
+
+func parseRateOptions(sq otsdbSubQuery) (rateSpec, error) {
+	rate := rateSpec{enabled: sq.Rate, counterMax: math.MaxInt64}
+	for k, v := range sq.RateOptions {
+		var ok bool
+		switch k {
+		case "counter":
+			rate.counter, ok = v.(bool)
+		case "dropResets":
+			rate.dropResets, ok = v.(bool)
+		case "counterMax":
+			rate.counterMax, ok = v.(float64)
+		case "resetValue":
+			rate.resetValue, ok = v.(float64)
+		}
+		if !ok {
+			return rateSpec{}, fmt.Errorf("unsupported rate option %s=%v", k, v)
+		}
+	}
+	return rate, nil
+}
+
+// returns the series that have the tag of f and match it
+func filterSeries(series []map[string]interface{}, f otsdbFilter) ([]map[string]interface{}, error) {
+	var match func(string) bool
+	switch f.Type {
+	case "literal_or", "not_literal_or":
+		literals := make(map[string]bool)
+		for _, l := range strings.Split(f.Filter, "|") {
+			literals[l] = true
+		}
+		negate := f.Type == "not_literal_or"
+		match = func(v string) bool { return literals[v] != negate }
+	case "wildcard":
+		match = func(v string) bool {
+			ok, _ := path.Match(f.Filter, v)
+			return ok
+		}
+	case "regexp":
+		re, err := regexp.Compile(f.Filter)
+		if err != nil {
+			return nil, err
+		}
+		match = re.MatchString
+	default:
+		return nil, fmt.Errorf("unsupported filter type %s", f.Type)
+	}
+	matched := make([]map[string]interface{}, 0, len(series))
+	for _, tags := range series {
+		v, ok := tags[f.Tagk]
+		if ok && match(fmt.Sprintf("%v", v)) {
+			matched = append(matched, tags)
+		}
+	}
+	return matched, nil
+}
+
+func groupKey(keys []string, value func(string) string) string {
+	values := make([]string, len(keys))
+	for i, k := range keys {
+		values[i] = k + "=" + value(k)
+	}
+	return strings.Join(values, ",")
+}
+
+// aggregates values with an OTSDB aggregator or downsample function. Returns false if fn is not supported
+func aggregate(fn string, values []float64) (float64, bool) {
+	if len(values) == 0 {
+		return 0, true
+	}
+	result := values[0]
+	switch fn {
+	case "sum", "zimsum", "avg":
+		for _, v := range values[1:] {
+			result += v
+		}
+		if fn == "avg" {
+			result /= float64(len(values))
+		}
+	case "min", "mimmin":
+		for _, v := range values[1:] {
+			result = math.Min(result, v)
+		}
+	case "max", "mimmax":
+		for _, v := range values[1:] {
+			result = math.Max(result, v)
+		}
+	case "count":
+		result = float64(len(values))
+	default:
+		return 0, false
+	}
+	return result, true
+}
@@ -0,0 +1,251 @@
+package query
+
+import (
+	"math"
+	"strconv"
+	"testing"
+	"time"
+	"verifier/pkg/utils"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// start of the checked range, a multiple of a minute
+const testValidationStart = int64(1_700_000_040)
+
+func initTestValidation(t *testing.T, model string) *MetricsValidation {
+	tags, err := utils.ParseTagSchema("host=3")
+	assert.NoError(t, err)
+	cfg := &utils.MetricsConfig{NumMetrics: 1, Tags: tags, ValueModel: model}
+	assert.NoError(t, cfg.Init())
+	return &MetricsValidation{Cfg: cfg, Interval: 10 * time.Second, Tolerance: 1e-9}
+}
+
+func testValue(t *testing.T, mv *MetricsValidation, host string, ts int64) float64 {
+	v, err := mv.Cfg.Value("testmetric0", map[string]interface{}{"host": host}, ts)
+	assert.NoError(t, err)
+	return v
+}
+
+func Test_aggregate(t *testing.T) {
+	cases := []struct {
+		fn       string
+		expected float64
+	}{
+		{"sum", 6}, {"zimsum", 6}, {"avg", 2}, {"min", 1}, {"mimmin", 1}, {"max", 3}, {"mimmax", 3}, {"count", 3},
+	}
+	for _, tc := range cases {
+		v, ok := aggregate(tc.fn, []float64{3, 1, 2})
+		assert.True(t, ok, tc.fn)
+		assert.Equal(t, tc.expected, v, tc.fn)
+	}
+	_, ok := aggregate("p99", []float64{1})
+	assert.False(t, ok)
+	v, ok := aggregate("sum", nil)
+	assert.True(t, ok)
+	assert.Equal(t, 0.0, v)
+}
+
+func Test_checkSubQueryAggregators(t *testing.T) {
+	mv := initTestValidation(t, utils.ValueGauge)
+	hosts := []string{"host-0", "host-1", "host-2"}
+	cases := []struct {
+		aggregator string
+		expected   func(values []float64) float64
+	}{
+		{"sum", func(v []float64) float64 { return v[0] + v[1] + v[2] }},
+		{"avg", func(v []float64) float64 { return (v[0] + v[1] + v[2]) / 3 }},
+		{"min", func(v []float64) float64 { return math.Min(v[0], math.Min(v[1], v[2])) }},
+		{"max", func(v []float64) float64 { return math.Max(v[0], math.Max(v[1], v[2])) }},
+		{"count", func(v []float64) float64 { return 3 }},
+	}
+	for _, tc := range cases {
+		r := otsdbResult{Metric: "testmetric0", Dps: make(map[string]*float64)}
+		for ts := testValidationStart; ts < testValidationStart+60; ts += 10 {
+			values := make([]float64, len(hosts))
+			for i, host := range hosts {
+				values[i] = testValue(t, mv, host, ts)
+			}
+			expected := tc.expected(values)
+			r.Dps[strconv.FormatInt(ts, 10)] = &expected
+		}
+		sq := otsdbSubQuery{Aggregator: tc.aggregator, Metric: "testmetric0"}
+		checked, mismatches, err := mv.checkSubQuery(sq, mv.subQueryMembers(sq).tags, []otsdbResult{r})
+		assert.NoError(t, err, tc.aggregator)
+		assert.Equal(t, 6, checked, tc.aggregator)
+		assert.Empty(t, mismatches, tc.aggregator)
+
+		wrong := *r.Dps[strconv.FormatInt(testValidationStart+20, 10)] + 1
+		r.Dps[strconv.FormatInt(testValidationStart+20, 10)] = &wrong
+		_, mismatches, err = mv.checkSubQuery(sq, mv.subQueryMembers(sq).tags, []otsdbResult{r})
+		assert.NoError(t, err, tc.aggregator)
+		assert.Len(t, mismatches, 1, tc.aggregator)
+	}
+	_, _, err := mv.checkSubQuery(otsdbSubQuery{Aggregator: "p99", Metric: "testmetric0"}, nil, nil)
+	assert.Error(t, err)
+}
+
+func Test_checkSubQueryDownsampleFill(t *testing.T) {
+	mv := initTestValidation(t, utils.ValueGauge)
+	// the middle bucket of each series is left out, zero or null. Every fill reports it as missing
+	cases := []struct {
+		downsample string
+		fn         func(values []float64) float64
+		hole       func(r otsdbResult, key string)
+		checked    int
+		mismatches int
+	}{
+		{
+			downsample: "1m-avg",
+			fn: func(v []float64) float64 {
+				sum := 0.0
+				for _, x := range v {
+					sum += x
+				}
+				return sum / float64(len(v))
+			},
+			hole:       func(r otsdbResult, key string) { delete(r.Dps, key) },
+			checked:    4,
+			mismatches: 2,
+		},
+		{
+			downsample: "1m-sum-zero",
+			fn: func(v []float64) float64 {
+				sum := 0.0
+				for _, x := range v {
+					sum += x
+				}
+				return sum
+			},
+			hole: func(r otsdbResult, key string) {
+				zero := 0.0
+				r.Dps[key] = &zero
+			},
+			// the zero is compared with the expected sum
+			checked:    6,
+			mismatches: 2,
+		},
+		{
+			downsample: "1m-max-null",
+			fn: func(v []float64) float64 {
+				max := v[0]
+				for _, x := range v[1:] {
+					max = math.Max(max, x)
+				}
+				return max
+			},
+			hole:       func(r otsdbResult, key string) { r.Dps[key] = nil },
+			checked:    4,
+			mismatches: 2,
+		},
+	}
+	for _, tc := range cases {
+		sq := otsdbSubQuery{Aggregator: "sum", Metric: "testmetric0", Downsample: tc.downsample,
+			Filters: []otsdbFilter{{Type: "literal_or", Tagk: "host", Filter: "host-0|host-1", GroupBy: true}}}
+		returned := make([]otsdbResult, 0)
+		for _, host := range []string{"host-0", "host-1"} {
+			r := otsdbResult{Metric: "testmetric0", Tags: map[string]string{"host": host}, Dps: make(map[string]*float64)}
+			for b := testValidationStart; b < testValidationStart+5*60; b += 60 {
+				values := make([]float64, 0, 6)
+				for ts := b; ts < b+60; ts += 10 {
+					values = append(values, testValue(t, mv, host, ts))
+				}
+				v := tc.fn(values)
+				r.Dps[strconv.FormatInt(b, 10)] = &v
+			}
+			returned = append(returned, r)
+		}
+
+		// the first and last buckets are not checked
+		checked, mismatches, err := mv.checkSubQuery(sq, mv.subQueryMembers(sq).tags, returned)
+		assert.NoError(t, err, tc.downsample)
+		assert.Equal(t, 6, checked, tc.downsample)
+		assert.Empty(t, mismatches, tc.downsample)
+
+		for _, r := range returned {
+			tc.hole(r, strconv.FormatInt(testValidationStart+2*60, 10))
+		}
+		checked, mismatches, err = mv.checkSubQuery(sq, mv.subQueryMembers(sq).tags, returned)
+		assert.NoError(t, err, tc.downsample)
+		assert.Equal(t, tc.checked, checked, tc.downsample)
+		assert.Len(t, mismatches, tc.mismatches, tc.downsample)
+
+		// filled buckets before any point is ingested are not expected to have data. The second bucket becomes the edge
+		if tc.downsample != "1m-avg" {
+			for _, r := range returned {
+				for b := testValidationStart - 3*60; b <= testValidationStart; b += 60 {
+					tc.hole(r, strconv.FormatInt(b, 10))
+				}
+			}
+			checked, mismatches, err = mv.checkSubQuery(sq, mv.subQueryMembers(sq).tags, returned)
+			assert.NoError(t, err, tc.downsample)
+			assert.Equal(t, tc.checked-2, checked, tc.downsample)
+			assert.Len(t, mismatches, 2, tc.downsample)
+		}
+	}
+}
+
+func Test_pointValueRate(t *testing.T) {
+	mv := initTestValidation(t, utils.ValueCounter)
+	tags := map[string]interface{}{"host": "host-0"}
+	// find a counter reset, which happens at least once every 25h
+	reset := int64(0)
+	for ts := testValidationStart; ts < testValidationStart+2*24*3600; ts += 10 {
+		if testValue(t, mv, "host-0", ts) < testValue(t, mv, "host-0", ts-10) {
+			reset = ts
+			break
+		}
+	}
+	assert.NotZero(t, reset)
+	prev, cur := testValue(t, mv, "host-0", reset-10), testValue(t, mv, "host-0", reset)
+	next := testValue(t, mv, "host-0", reset+10)
+
+	cases := []struct {
+		name    string
+		options map[string]interface{}
+		// value at the reset, or false if the point is dropped
+		atReset float64
+		kept    bool
+	}{
+		{name: "rate", atReset: (cur - prev) / 10, kept: true},
+		{name: "counter", options: map[string]interface{}{"counter": true}, atReset: (math.MaxInt64 - prev + cur) / 10, kept: true},
+		{name: "counter resetValue", options: map[string]interface{}{"counter": true, "resetValue": 1.0}, atReset: 0, kept: true},
+		{name: "counter dropResets", options: map[string]interface{}{"counter": true, "dropResets": true}, kept: false},
+		{name: "dropResets without counter", options: map[string]interface{}{"dropResets": true}, atReset: (cur - prev) / 10, kept: true},
+	}
+	for _, tc := range cases {
+		rate, err := parseRateOptions(otsdbSubQuery{Rate: true, RateOptions: tc.options})
+		assert.NoError(t, err, tc.name)
+		v, ok := mv.pointValue("testmetric0", tags, reset, 10, downsampleSpec{}, rate, 10)
+		assert.Equal(t, tc.kept, ok, tc.name)
+		if tc.kept {
+			assert.Equal(t, tc.atReset, v, tc.name)
+		}
+		// after the reset the counter increases again
+		v, ok = mv.pointValue("testmetric0", tags, reset+10, 10, downsampleSpec{}, rate, 10)
+		assert.True(t, ok, tc.name)
+		assert.Equal(t, (next-cur)/10, v, tc.name)
+	}
+	_, err := parseRateOptions(otsdbSubQuery{Rate: true, RateOptions: map[string]interface{}{"counter": "yes"}})
+	assert.Error(t, err)
+}
+
+func Test_wildcardFilterSelectsSomeSeries(t *testing.T) {
+	for _, schema := range []string{"a=12", "host=3"} {
+		tags, err := utils.ParseTagSchema(schema)
+		assert.NoError(t, err)
+		mv := &MetricsValidation{Cfg: &utils.MetricsConfig{NumMetrics: 1, Tags: tags}, Interval: 10 * time.Second}
+		assert.NoError(t, mv.Cfg.Init())
+		tagKey, tagValues, err := mv.queryTags()
+		assert.NoError(t, err)
+
+		query, err := getMetricsQuery(wildcardFilter, "http://localhost:4242/api/query", "sum", tagKey, tagValues)
+		assert.NoError(t, err)
+		all, err := mv.Cfg.SeriesOf("testmetric0")
+		assert.NoError(t, err)
+		filtered, err := filterSeries(all, query.request.Queries[0].Filters[0])
+		assert.NoError(t, err)
+		assert.NotEmpty(t, filtered, schema)
+		assert.Less(t, len(filtered), len(all), schema)
+	}
+}
@@ -0,0 +1,335 @@
+package query
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+	"verifier/pkg/utils"
+
+	"github.com/montanaflynn/stats"
+	log "github.com/sirupsen/logrus"
+)
+
+type promQLQueryTypes int
+
+const (
+	rangeRate promQLQueryTypes = iota
+	rangeSumBy
+	instantSumBy
+	histogramQuantile
+	topkQuery
+	regexMatcher
+	negativeRegexMatcher
+	subQuery
+)
+
+var allPromQLQueryTypes = []promQLQueryTypes{rangeRate, rangeSumBy, instantSumBy, histogramQuantile, topkQuery,
+	regexMatcher, negativeRegexMatcher, subQuery}
+
+// range queries cover the last promQLRange with a point every promQLStep
+const (
+	promQLRange = time.Hour
+	promQLStep  = time.Minute
+	// how far back prometheus looks for the latest sample of a series
+	promQLLookback = 5 * time.Minute
+	topkSize       = 3
+)
+
+// quantiles returned by the histogram_quantile query
+var histogramQuantiles = []float64{0.5, 0.9, 0.99}
+
+func (p promQLQueryTypes) String() string {
+	switch p {
+	case rangeRate:
+		return "rate"
+	case rangeSumBy:
+		return "sum by"
+	case instantSumBy:
+		return "instant sum by"
+	case histogramQuantile:
+		return "histogram_quantile"
+	case topkQuery:
+		return "topk"
+	case regexMatcher:
+		return "regex matcher"
+	case negativeRegexMatcher:
+		return "negative regex matcher"
+	case subQuery:
+		return "subquery"
+	default:
+		return "UNKNOWN"
+	}
+}
+
+// promQLQuery is a single query of the suite. Instant queries are evaluated at the current time
+type promQLQuery struct {
+	expr    string
+	instant bool
+
+	// series selected by expr, used to recompute the returned values. metric is empty if they can not be recomputed
+	metric  string
+	filters []otsdbFilter
+	// if set, series are summed by this label
+	sumBy string
+	// if set, only the topk series are returned
+	topk int
+	// if set, values can not be negative, e.g. the rate of a counter
+	nonNegative bool
+	// if set, each returned series is a quantile of a histogram with its value in the quantile label
+	quantiles []float64
+}
+
+// names of the metrics of each value model used by the suite
+type promQLMetrics struct {
+	counter   string
+	gauge     string
+	histogram string
+}
+
+type promQLResponse struct {
+	Status    string `json:"status"`
+	ErrorType string `json:"errorType"`
+	Error     string `json:"error"`
+	Data      struct {
+		ResultType string         `json:"resultType"`
+		Result     []promQLSeries `json:"result"`
+	} `json:"data"`
+}
+
+// promQLSeries is an element of a vector, which has a Value, or of a matrix, which has Values.
+// Each sample is a [timestamp, "value"] pair
+type promQLSeries struct {
+	Metric map[string]string `json:"metric"`
+	Value  []interface{}     `json:"value"`
+	Values [][]interface{}   `json:"values"`
+}
+
+// returns the metrics used by the suite. Without a tag schema, the metrics of the mixed value model are assumed
+func getPromQLMetrics(validation *MetricsValidation) promQLMetrics {
+	metrics := promQLMetrics{counter: "testmetric0", gauge: "testmetric1", histogram: "testmetric4_bucket"}
+	if validation == nil {
+		return metrics
+	}
+	cfg := validation.Cfg
+	var ok bool
+	if metrics.counter, ok = cfg.MetricOfModel(utils.ValueCounter); !ok {
+		metrics.counter = ""
+	}
+	if metrics.gauge, ok = cfg.MetricOfModel(utils.ValueGauge); !ok {
+		// every other model can be summed as well
+		metrics.gauge, _ = cfg.MetricOfModel(cfg.ValueModel)
+	}
+	if metrics.histogram, ok = cfg.MetricOfModel(utils.ValueHistogram); !ok {
+		metrics.histogram = ""
+	}
+	return metrics
+}
+
+// returns the query of pqType, or nil if the ingested metrics have no metric it can run on
+func getPromQLQuery(pqType promQLQueryTypes, metrics promQLMetrics, tagKey string, tagValues []string) *promQLQuery {
+	regex := strings.Join(tagValues[:2], "|")
+	switch pqType {
+	case rangeRate:
+		if metrics.counter == "" {
+			return nil
+		}
+		return &promQLQuery{expr: fmt.Sprintf("rate(%s[5m])", metrics.counter), nonNegative: true}
+	case rangeSumBy:
+		return &promQLQuery{expr: fmt.Sprintf("sum by (%s) (%s)", tagKey, metrics.gauge), metric: metrics.gauge, sumBy: tagKey}
+	case instantSumBy:
+		return &promQLQuery{expr: fmt.Sprintf("sum by (%s) (%s)", tagKey, metrics.gauge), instant: true, metric: metrics.gauge, sumBy: tagKey}
+	case histogramQuantile:
+		if metrics.histogram == "" {
+			return nil
+		}
+		return &promQLQuery{expr: getHistogramQuantileExpr(metrics.histogram, histogramQuantiles), quantiles: histogramQuantiles}
+	case topkQuery:
+		return &promQLQuery{expr: fmt.Sprintf("topk(%d, %s)", topkSize, metrics.gauge), instant: true, metric: metrics.gauge, topk: topkSize}
+	case regexMatcher:
+		return &promQLQuery{
+			expr:    fmt.Sprintf(`%s{%s=~"%s"}`, metrics.gauge, tagKey, regex),
+			metric:  metrics.gauge,
+			filters: []otsdbFilter{{Type: "literal_or", Tagk: tagKey, Filter: regex}},
+		}
+	case negativeRegexMatcher:
+		return &promQLQuery{
+			expr:    fmt.Sprintf(`%s{%s!~"%s"}`, metrics.gauge, tagKey, regex),
+			metric:  metrics.gauge,
+			filters: []otsdbFilter{{Type: "not_literal_or", Tagk: tagKey, Filter: regex}},
+		}
+	case subQuery:
+		if metrics.counter == "" {
+			return nil
+		}
+		return &promQLQuery{expr: fmt.Sprintf("max_over_time(rate(%s[5m])[1h:5m])", metrics.counter), instant: true, nonNegative: true}
+	default:
+		return nil
+	}
+}
+
+// returns the quantiles of histogram as one query. Each quantile gets its value in the quantile label so the results
+// of the quantiles can be told apart
+func getHistogramQuantileExpr(histogram string, quantiles []float64) string {
+	exprs := make([]string, len(quantiles))
+	for i, q := range quantiles {
+		exprs[i] = fmt.Sprintf(`label_replace(histogram_quantile(%v, sum by (le) (rate(%s[5m]))), "quantile", "%v", "", "")`, q, histogram, q)
+	}
+	return strings.Join(exprs, " or ")
+}
+
+// describes how the values of pq are validated, or returns "" if only the structure of its responses is
+func (pq *promQLQuery) valueChecks(validation *MetricsValidation) string {
+	switch {
+	case pq.metric != "" && validation != nil:
+		return "recomputed from the ingested series"
+	case pq.nonNegative:
+		return "checked to be non negative"
+	case len(pq.quantiles) > 0:
+		return "checked to be within the bucket bounds and not to decrease with the quantile"
+	default:
+		return ""
+	}
+}
+
+// returns the url of the query evaluated at or ending at now
+func (pq *promQLQuery) url(dest string, now int64) string {
+	values := url.Values{}
+	values.Set("query", pq.expr)
+	if pq.instant {
+		values.Set("time", strconv.FormatInt(now, 10))
+		return fmt.Sprintf("%s/api/v1/query?%s", dest, values.Encode())
+	}
+	values.Set("start", strconv.FormatInt(now-int64(promQLRange.Seconds()), 10))
+	values.Set("end", strconv.FormatInt(now, 10))
+	values.Set("step", strconv.FormatInt(int64(promQLStep.Seconds()), 10))
+	return fmt.Sprintf("%s/api/v1/query_range?%s", dest, values.Encode())
+}
+
+// Returns elapsed time and the response. Returns an error if the request fails or the response is not a successful
+// query api response, including the error type and message of error responses. If verbose, logs the returned series
+func sendSinglePromQLRequest(client *http.Client, pqType promQLQueryTypes, reqUrl string, verbose bool) (float64, *promQLResponse, error) {
+	stime := time.Now()
+	resp, err := client.Get(reqUrl)
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+	rawBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to read response: %w", err)
+	}
+	elapsed := time.Since(stime)
+	pr := &promQLResponse{}
+	err = json.Unmarshal(rawBody, pr)
+	if resp.StatusCode/100 != 2 || err != nil || pr.Status != "success" {
+		return 0, nil, parsePromQLError(resp.StatusCode, rawBody)
+	}
+	log.Infof("returned response: %v in %+v. Status=%v, Num series=%+v", pqType, elapsed, pr.Status, len(pr.Data.Result))
+	if verbose {
+		log.Infof("%v response: %s", pqType, rawBody)
+	}
+	return float64(elapsed.Milliseconds()), pr, nil
+}
+
+func parsePromQLError(statusCode int, rawBody []byte) error {
+	pr := promQLResponse{}
+	if json.Unmarshal(rawBody, &pr) == nil && pr.Error != "" {
+		return fmt.Errorf("status %d: %s: %s", statusCode, pr.ErrorType, pr.Error)
+	}
+	body := string(rawBody)
+	if len(body) > maxErrorBodyLen {
+		body = body[:maxErrorBodyLen] + "..."
+	}
+	return fmt.Errorf("status %d: response is not a successful query api response: %s", statusCode, body)
+}
+
+// Each request times out after timeout. Failed requests are counted per query type instead of stopping the suite.
+// If validateOutput, the result type and samples of every response are checked. If validation is also set, the
+// returned values are checked against the ingested series it describes
+func StartPromQLQuery(dest string, numIterations int, continuous, verbose, validateOutput bool, timeout time.Duration, validation *MetricsValidation) map[string]bool {
+	client := &http.Client{Timeout: timeout}
+	if numIterations == 0 && !continuous {
+		log.Fatalf("Iterations must be greater than 0")
+	}
+	tagKey, tagValues := queryTagKey, queryTagValues
+	if validation != nil {
+		var err error
+		tagKey, tagValues, err = validation.queryTags()
+		if err != nil {
+			log.Fatalf("Invalid metrics validation options: %+v", err)
+		}
+	}
+	metrics := getPromQLMetrics(validation)
+	queries := make(map[promQLQueryTypes]*promQLQuery)
+	results := make(map[promQLQueryTypes][]float64)
+	for _, pqType := range allPromQLQueryTypes {
+		pq := getPromQLQuery(pqType, metrics, tagKey, tagValues)
+		if pq == nil {
+			log.Infof("skipping %v query. The ingested metrics have no metric it can run on", pqType)
+			continue
+		}
+		log.Infof("%v query: %s", pqType, pq.expr)
+		if validateOutput {
+			if checks := pq.valueChecks(validation); checks != "" {
+				log.Infof("%v query: values are %s", pqType, checks)
+			} else {
+				log.Infof("%v query: only the structure of responses is validated", pqType)
+			}
+		}
+		queries[pqType] = pq
+		results[pqType] = make([]float64, 0, numIterations)
+	}
+
+	validResult := make(map[string]bool)
+	numSent := make(map[promQLQueryTypes]int)
+	numErrors := make(map[promQLQueryTypes]int)
+	for i := 0; i < numIterations || continuous; i++ {
+		for _, pqType := range allPromQLQueryTypes {
+			pq, ok := queries[pqType]
+			if !ok {
+				continue
+			}
+			now := time.Now().Unix()
+			numSent[pqType]++
+			time, resp, err := sendSinglePromQLRequest(client, pqType, pq.url(dest, now), verbose)
+			if err != nil {
+				numErrors[pqType]++
+				log.Errorf("%v query failed: %v", pqType, err)
+				if validateOutput {
+					validResult[pqType.String()] = false
+				}
+				continue
+			}
+			if !continuous {
+				results[pqType] = append(results[pqType], time)
+			}
+			if validateOutput && !checkPromQLResponse(pqType, pq, resp, now, validation) {
+				validResult[pqType.String()] = false
+			}
+		}
+	}
+
+	log.Infof("-----Query Summary. Completed %d iterations----", numIterations)
+	for _, pqType := range allPromQLQueryTypes {
+		qRes, ok := results[pqType]
+		if !ok {
+			continue
+		}
+		p95, _ := stats.Percentile(qRes, 95)
+		avg, _ := stats.Mean(qRes)
+		max, _ := stats.Max(qRes)
+		min, _ := stats.Min(qRes)
+		errorRate := 0.0
+		if numSent[pqType] > 0 {
+			errorRate = 100 * float64(numErrors[pqType]) / float64(numSent[pqType])
+		}
+		log.Infof("QueryType: %s. Min:%+vms, Max:%+vms, Avg:%+vms, P95:%+vms, Errors:%d/%d (%.2f%%)", pqType.String(), min, max, avg, p95,
+			numErrors[pqType], numSent[pqType], errorRate)
+	}
+	return validResult
+}
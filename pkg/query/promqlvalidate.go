@@ -0,0 +1,291 @@
+package query
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"verifier/pkg/utils"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// checkPromQLResponse checks the result type and samples of the successful response resp, evaluated at or ending at now, and logs the problems.
+// If validation is set and the values of pq can be recomputed, they are compared with the ingested series. Otherwise,
+// values are only checked against the bounds of pq, see promQLQuery.valueChecks.
+// Returns false if there is any problem
+func checkPromQLResponse(pqType promQLQueryTypes, pq *promQLQuery, resp *promQLResponse, now int64, validation *MetricsValidation) bool {
+	problems := make([]string, 0)
+	checked := 0
+	expectedType := "matrix"
+	if pq.instant {
+		expectedType = "vector"
+	}
+	switch {
+	case resp.Data.ResultType != expectedType:
+		problems = append(problems, fmt.Sprintf("expected a %s, got a %s", expectedType, resp.Data.ResultType))
+	case len(resp.Data.Result) == 0:
+		problems = append(problems, "no series returned")
+	case pq.topk > 0 && len(resp.Data.Result) > pq.topk:
+		problems = append(problems, fmt.Sprintf("expected at most %d series, got %d", pq.topk, len(resp.Data.Result)))
+	case len(pq.quantiles) > 0 && len(resp.Data.Result) != len(pq.quantiles):
+		problems = append(problems, fmt.Sprintf("expected %d quantiles, got %d series", len(pq.quantiles), len(resp.Data.Result)))
+	}
+
+	var expected *promQLExpected
+	if len(problems) == 0 && validation != nil && pq.metric != "" {
+		var err error
+		expected, err = newPromQLExpected(pq, validation)
+		if err != nil {
+			log.Infof("not validating values of %v query: %v", pqType, err)
+		} else if len(resp.Data.Result) != expected.numSeries() {
+			problems = append(problems, fmt.Sprintf("expected %d series, got %d", expected.numSeries(), len(resp.Data.Result)))
+		}
+	}
+
+	if len(problems) == 0 {
+		start := now - int64(promQLRange.Seconds())
+		step := int64(promQLStep.Seconds())
+		// value of each quantile at each timestamp
+		quantileValues := make(map[int64]map[float64]float64)
+		for _, series := range resp.Data.Result {
+			samples := series.Values
+			if pq.instant {
+				samples = [][]interface{}{series.Value}
+			}
+			var quantile float64
+			if len(pq.quantiles) > 0 {
+				var err error
+				quantile, err = strconv.ParseFloat(series.Metric["quantile"], 64)
+				if err != nil || !containsFloat(pq.quantiles, quantile) {
+					problems = append(problems, fmt.Sprintf("unexpected series %v", series.Metric))
+					continue
+				}
+			}
+			var group []map[string]interface{}
+			if expected != nil {
+				group = expected.groupOf(series.Metric)
+				if len(group) == 0 {
+					problems = append(problems, fmt.Sprintf("unexpected series %v", series.Metric))
+					continue
+				}
+			}
+			for _, sample := range samples {
+				ts, value, err := parsePromQLSample(sample)
+				if err != nil {
+					problems = append(problems, fmt.Sprintf("%v: %v", series.Metric, err))
+					continue
+				}
+				if pq.instant && ts != now {
+					problems = append(problems, fmt.Sprintf("%v: expected a sample at %d, got %d", series.Metric, now, ts))
+				}
+				if !pq.instant && (ts < start || ts > now || (ts-start)%step != 0) {
+					problems = append(problems, fmt.Sprintf("%v: timestamp %d is not a step between %d and %d", series.Metric, ts, start, now))
+				}
+				if pq.nonNegative && value < 0 {
+					problems = append(problems, fmt.Sprintf("%v at %d: %v is negative", series.Metric, ts, value))
+				}
+				if len(pq.quantiles) > 0 {
+					if quantileValues[ts] == nil {
+						quantileValues[ts] = make(map[float64]float64)
+					}
+					quantileValues[ts][quantile] = value
+				}
+				if expected == nil {
+					continue
+				}
+				checked++
+				problem := expected.check(group, ts, value)
+				if problem != "" {
+					problems = append(problems, fmt.Sprintf("%v at %d: %s", series.Metric, ts, problem))
+				}
+			}
+		}
+		problems = append(problems, checkQuantiles(pq.quantiles, quantileValues)...)
+	}
+
+	for i, p := range problems {
+		if i == maxLoggedMismatches {
+			log.Errorf("%v query: %d more problems", pqType, len(problems)-maxLoggedMismatches)
+			break
+		}
+		log.Errorf("%v query: %s", pqType, p)
+	}
+	if expected != nil {
+		log.Infof("validated values of %v query. Samples checked=%d, problems=%d", pqType, checked, len(problems))
+	}
+	return len(problems) == 0
+}
+
+// checks that the quantiles of a histogram are within its buckets and do not decrease with the quantile.
+// All quantiles are NaN at timestamps where the histogram has no observations
+func checkQuantiles(quantiles []float64, values map[int64]map[float64]float64) []string {
+	buckets := utils.HistogramBuckets()
+	// a quantile in the +Inf bucket is the upper bound of the largest finite bucket
+	maxBound := buckets[len(buckets)-2]
+	timestamps := make([]int64, 0, len(values))
+	for ts := range values {
+		timestamps = append(timestamps, ts)
+	}
+	sort.Slice(timestamps, func(i, j int) bool { return timestamps[i] < timestamps[j] })
+
+	problems := make([]string, 0)
+	for _, ts := range timestamps {
+		byQuantile := values[ts]
+		numNaN := 0
+		prev := math.Inf(-1)
+		for _, q := range quantiles {
+			value, ok := byQuantile[q]
+			switch {
+			case !ok:
+				// range queries may not have every step of every quantile
+			case math.IsNaN(value):
+				numNaN++
+			case value < 0 || value > maxBound:
+				problems = append(problems, fmt.Sprintf("quantile %v at %d: %v is outside the buckets [0, %v]", q, ts, value, maxBound))
+			case value < prev:
+				problems = append(problems, fmt.Sprintf("quantile %v at %d: %v is smaller than a lower quantile", q, ts, value))
+			}
+			if ok && !math.IsNaN(value) {
+				prev = math.Max(prev, value)
+			}
+		}
+		if numNaN > 0 && numNaN != len(byQuantile) {
+			problems = append(problems, fmt.Sprintf("only %d of the quantiles at %d are NaN", numNaN, ts))
+		}
+	}
+	return problems
+}
+
+func containsFloat(list []float64, f float64) bool {
+	for _, l := range list {
+		if l == f {
+			return true
+		}
+	}
+	return false
+}
+
+// returns the timestamp in epoch seconds and the value of a [timestamp, "value"] pair
+func parsePromQLSample(sample []interface{}) (int64, float64, error) {
+	if len(sample) != 2 {
+		return 0, 0, fmt.Errorf("invalid sample %v", sample)
+	}
+	ts, ok := sample[0].(float64)
+	rawValue, isString := sample[1].(string)
+	if !ok || !isString {
+		return 0, 0, fmt.Errorf("invalid sample %v", sample)
+	}
+	value, err := strconv.ParseFloat(rawValue, 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid value %v", rawValue)
+	}
+	return int64(math.Round(ts)), value, nil
+}
+
+// promQLExpected recomputes the values of a query from the ingested series
+type promQLExpected struct {
+	pq         *promQLQuery
+	validation *MetricsValidation
+	// series selected by the query
+	members []map[string]interface{}
+}
+
+func newPromQLExpected(pq *promQLQuery, validation *MetricsValidation) (*promQLExpected, error) {
+	members, err := validation.Cfg.SeriesOf(pq.metric)
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range pq.filters {
+		members, err = filterSeries(members, f)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &promQLExpected{pq: pq, validation: validation, members: members}, nil
+}
+
+func (pe *promQLExpected) numSeries() int {
+	switch {
+	case pe.pq.sumBy != "":
+		values := make(map[string]bool)
+		for _, tags := range pe.members {
+			values[fmt.Sprintf("%v", tags[pe.pq.sumBy])] = true
+		}
+		return len(values)
+	case pe.pq.topk > 0 && pe.pq.topk < len(pe.members):
+		return pe.pq.topk
+	default:
+		return len(pe.members)
+	}
+}
+
+// returns the series that are summed into a returned series with labels
+func (pe *promQLExpected) groupOf(labels map[string]string) []map[string]interface{} {
+	group := make([]map[string]interface{}, 0)
+	for _, tags := range pe.members {
+		matches := true
+		for k, v := range labels {
+			if k != "__name__" && fmt.Sprintf("%v", tags[k]) != v {
+				matches = false
+				break
+			}
+		}
+		if matches {
+			group = append(group, tags)
+		}
+	}
+	return group
+}
+
+// check compares value with the sum of group at ts and returns a description of the mismatch, if any.
+// Prometheus uses the latest sample within the lookback, so earlier samples are tried in case ingestion stopped before ts
+func (pe *promQLExpected) check(group []map[string]interface{}, ts int64, value float64) string {
+	interval := int64(pe.validation.Interval.Seconds())
+	latest := ts - ts%interval
+	var want float64
+	for sampleTs := latest; sampleTs > ts-int64(promQLLookback.Seconds()); sampleTs -= interval {
+		sum := pe.sum(group, sampleTs)
+		if sampleTs == latest {
+			want = sum
+		}
+		if !pe.matches(value, sum) {
+			continue
+		}
+		if pe.pq.topk > 0 {
+			return pe.checkTopk(value, sampleTs)
+		}
+		return ""
+	}
+	return fmt.Sprintf("expected %v, got %v", want, value)
+}
+
+// checks that no more than topk-1 selected series are larger than value at sampleTs
+func (pe *promQLExpected) checkTopk(value float64, sampleTs int64) string {
+	values := make([]float64, 0, len(pe.members))
+	for _, tags := range pe.members {
+		values = append(values, pe.sum([]map[string]interface{}{tags}, sampleTs))
+	}
+	sort.Sort(sort.Reverse(sort.Float64Slice(values)))
+	kth := values[len(values)-1]
+	if pe.pq.topk < len(values) {
+		kth = values[pe.pq.topk-1]
+	}
+	if value < kth && !pe.matches(value, kth) {
+		return fmt.Sprintf("%v is not in the top %d. Smallest expected value is %v", value, pe.pq.topk, kth)
+	}
+	return ""
+}
+
+func (pe *promQLExpected) sum(group []map[string]interface{}, ts int64) float64 {
+	sum := 0.0
+	for _, tags := range group {
+		v, _ := pe.validation.Cfg.Value(pe.pq.metric, tags, ts)
+		sum += v
+	}
+	return sum
+}
+
+func (pe *promQLExpected) matches(got, want float64) bool {
+	return math.Abs(got-want) <= pe.validation.Tolerance*math.Max(math.Abs(want), 1)
+}
@@ -0,0 +1,115 @@
+package query
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_checkQuantiles(t *testing.T) {
+	cases := []struct {
+		name     string
+		values   map[int64]map[float64]float64
+		problems int
+	}{
+		{
+			name: "increasing",
+			values: map[int64]map[float64]float64{
+				60:  {0.5: 0.2, 0.9: 1.5, 0.99: 10},
+				120: {0.5: 0.3, 0.9: 0.3, 0.99: 4},
+			},
+		},
+		{
+			name:   "no observations",
+			values: map[int64]map[float64]float64{60: {0.5: math.NaN(), 0.9: math.NaN(), 0.99: math.NaN()}},
+		},
+		{
+			name:   "missing step",
+			values: map[int64]map[float64]float64{60: {0.5: 0.2, 0.99: 1}},
+		},
+		{
+			name:     "decreasing",
+			values:   map[int64]map[float64]float64{60: {0.5: 2, 0.9: 1, 0.99: 3}},
+			problems: 1,
+		},
+		{
+			name:     "above the largest finite bucket",
+			values:   map[int64]map[float64]float64{60: {0.5: 1, 0.9: 5, 0.99: 11}},
+			problems: 1,
+		},
+		{
+			name:     "negative",
+			values:   map[int64]map[float64]float64{60: {0.5: -1, 0.9: 5, 0.99: 6}},
+			problems: 1,
+		},
+		{
+			name:     "some NaN",
+			values:   map[int64]map[float64]float64{60: {0.5: 1, 0.9: math.NaN(), 0.99: 6}},
+			problems: 1,
+		},
+	}
+	for _, tc := range cases {
+		assert.Len(t, checkQuantiles(histogramQuantiles, tc.values), tc.problems, tc.name)
+	}
+}
+
+// returns a matrix response with a series per labels, each with a sample per value at the last steps before now
+func testPromQLMatrix(now int64, series map[string][]string) *promQLResponse {
+	resp := &promQLResponse{Status: "success"}
+	resp.Data.ResultType = "matrix"
+	step := int64(promQLStep.Seconds())
+	for labels, values := range series {
+		s := promQLSeries{Metric: make(map[string]string)}
+		for _, kv := range strings.Split(labels, ",") {
+			parts := strings.SplitN(kv, "=", 2)
+			s.Metric[parts[0]] = parts[1]
+		}
+		for i, v := range values {
+			ts := now - int64(len(values)-1-i)*step
+			s.Values = append(s.Values, []interface{}{float64(ts), v})
+		}
+		resp.Data.Result = append(resp.Data.Result, s)
+	}
+	return resp
+}
+
+func Test_checkPromQLResponseBounds(t *testing.T) {
+	now := int64(1_700_000_040)
+	metrics := promQLMetrics{counter: "testmetric0", gauge: "testmetric1", histogram: "testmetric4_bucket"}
+	rate := getPromQLQuery(rangeRate, metrics, "host", []string{"host-0", "host-1"})
+	quantiles := getPromQLQuery(histogramQuantile, metrics, "host", []string{"host-0", "host-1"})
+	quantileSeries := func(values ...string) map[string][]string {
+		series := make(map[string][]string)
+		for i, q := range histogramQuantiles {
+			series[fmt.Sprintf("quantile=%v", q)] = []string{values[i]}
+		}
+		return series
+	}
+
+	cases := []struct {
+		name  string
+		pq    *promQLQuery
+		resp  *promQLResponse
+		valid bool
+	}{
+		{"rate", rate, testPromQLMatrix(now, map[string][]string{"__name__=testmetric0,host=host-0": {"0", "1.5", "0.25"}}), true},
+		{"negative rate", rate, testPromQLMatrix(now, map[string][]string{"__name__=testmetric0,host=host-0": {"0", "-1.5"}}), false},
+		{"quantiles", quantiles, testPromQLMatrix(now, quantileSeries("0.1", "2.5", "9.5")), true},
+		{"decreasing quantiles", quantiles, testPromQLMatrix(now, quantileSeries("0.1", "2.5", "1")), false},
+		{"quantile outside the buckets", quantiles, testPromQLMatrix(now, quantileSeries("0.1", "2.5", "+Inf")), false},
+		{"unexpected quantile", quantiles, testPromQLMatrix(now, map[string][]string{"quantile=0.5": {"1"}, "quantile=0.9": {"1"}, "quantile=0.95": {"1"}}), false},
+		{"missing quantile", quantiles, testPromQLMatrix(now, map[string][]string{"quantile=0.5": {"1"}, "quantile=0.9": {"1"}}), false},
+	}
+	for _, tc := range cases {
+		assert.Equal(t, tc.valid, checkPromQLResponse(histogramQuantile, tc.pq, tc.resp, now, nil), tc.name)
+	}
+}
+
+func Test_getHistogramQuantileExpr(t *testing.T) {
+	assert.Equal(t, `label_replace(histogram_quantile(0.5, sum by (le) (rate(m_bucket[5m]))), "quantile", "0.5", "", "")`+
+		` or label_replace(histogram_quantile(0.99, sum by (le) (rate(m_bucket[5m]))), "quantile", "0.99", "", "")`,
+		getHistogramQuantileExpr("m_bucket", []float64{0.5, 0.99}))
+}
@@ -15,7 +15,6 @@ import (
 	"time"
 
 	"github.com/brianvoe/gofakeit/v6"
-	"github.com/fasthttp/websocket"
 	"github.com/montanaflynn/stats"
 
 	log "github.com/sirupsen/logrus"
@@ -31,8 +30,27 @@ const (
 	keyValueQuery
 	freeText
 	random
+	termsCityAgg
+	termsHttpMethodAgg
+	dateHistogramAgg
+	nestedTermsAvgAgg
+	cardinalityAgg
+	percentilesAgg
+	tailLatest
+	fromSizePagination
+	searchAfterPagination
+	scrollPagination
+	countQuery
+	msearchBatch
 )
 
+// filter queries are also bundled into _msearch batches
+var filterQueryTypes = []logsQueryTypes{matchAll, matchMultiple, matchRange, needleInHaystack, keyValueQuery, freeText}
+
+var aggQueryTypes = []logsQueryTypes{termsCityAgg, termsHttpMethodAgg, dateHistogramAgg, nestedTermsAvgAgg, cardinalityAgg, percentilesAgg}
+
+var paginationQueryTypes = []logsQueryTypes{tailLatest, fromSizePagination, searchAfterPagination, scrollPagination}
+
 func (q logsQueryTypes) String() string {
 	switch q {
 	case matchAll:
@@ -49,6 +67,30 @@ func (q logsQueryTypes) String() string {
 		return "free text"
 	case random:
 		return "random"
+	case termsCityAgg:
+		return "terms city"
+	case termsHttpMethodAgg:
+		return "terms http_method"
+	case dateHistogramAgg:
+		return "date_histogram"
+	case nestedTermsAvgAgg:
+		return "nested terms avg"
+	case cardinalityAgg:
+		return "cardinality"
+	case percentilesAgg:
+		return "percentiles"
+	case tailLatest:
+		return "tail latest"
+	case fromSizePagination:
+		return "from/size pages"
+	case searchAfterPagination:
+		return "search_after pages"
+	case scrollPagination:
+		return "scroll pages"
+	case countQuery:
+		return "count"
+	case msearchBatch:
+		return "msearch batch"
 	default:
 		return "UNKNOWN"
 	}
@@ -306,7 +348,7 @@ func getFreeTextSearch() []byte {
 
 // This generates queries based on columns/values that are setup when ingesting
 // data dynamically, so running this function may not be useful when data was
-// ingested differently.
+// ingested differently. Use an ESSchema for queries over other data.
 // The resulting query has one or more key=value conditions for string fields.
 func getRandomQuery() []byte {
 	faker := gofakeit.NewUnlocked(time.Now().UnixNano())
@@ -403,7 +445,81 @@ func getRandomQuery() []byte {
 }
 
 func sendSingleRequest(qType logsQueryTypes, client *http.Client, body []byte, url string, verbose bool, authToken string) float64 {
-	req, err := http.NewRequest("POST", url, bytes.NewReader(body))
+	m := sendESRequest(client, "POST", body, url, authToken)
+	return validateAndGetElapsedTime(qType, m, verbose)
+}
+
+// Returns elapsed time. If validateAggs, also returns false and logs the problems if the aggregations are malformed
+func sendAggRequest(qType logsQueryTypes, client *http.Client, url string, verbose bool, authToken string, validateAggs bool) (float64, bool) {
+	m := sendESRequest(client, "POST", getAggQueryOfType(qType), url, authToken)
+	elapsed := validateAndGetElapsedTime(qType, m, verbose)
+	if !validateAggs {
+		return elapsed, true
+	}
+	problems := checkAggregations(qType, m)
+	for i, p := range problems {
+		if i == maxLoggedMismatches {
+			log.Errorf("%s query: %d more problems", qType.String(), len(problems)-maxLoggedMismatches)
+			break
+		}
+		log.Errorf("%s query: %s", qType.String(), p)
+	}
+	return elapsed, len(problems) == 0
+}
+
+func getFilterQuery(qType logsQueryTypes) []byte {
+	switch qType {
+	case matchAll:
+		return getMatchAllQuery()
+	case matchMultiple:
+		return getMatchMultipleQuery()
+	case matchRange:
+		return getRangeQuery()
+	case needleInHaystack:
+		return getNeedleInHaystackQuery()
+	case keyValueQuery:
+		return getSimpleFilter()
+	case freeText:
+		return getFreeTextSearch()
+	default:
+		log.Fatalf("%s is not a filter query", qType.String())
+		return nil
+	}
+}
+
+// Returns elapsed time and the number of hits
+func sendFilterRequest(qType logsQueryTypes, client *http.Client, body []byte, url string, verbose bool, authToken string) (float64, float64) {
+	m := sendESRequest(client, "POST", body, url, authToken)
+	elapsed := validateAndGetElapsedTime(qType, m, verbose)
+	hits, ok := getHitsTotal(m)
+	if !ok {
+		log.Errorf("%s query: response has no hits.total", qType.String())
+	}
+	return elapsed, hits
+}
+
+func getAggQueryOfType(qType logsQueryTypes) []byte {
+	switch qType {
+	case termsCityAgg:
+		return getTermsAggQuery("city")
+	case termsHttpMethodAgg:
+		return getTermsAggQuery("http_method")
+	case dateHistogramAgg:
+		return getDateHistogramQuery()
+	case nestedTermsAvgAgg:
+		return getNestedTermsAvgQuery()
+	case cardinalityAgg:
+		return getCardinalityQuery()
+	case percentilesAgg:
+		return getPercentilesQuery()
+	default:
+		log.Fatalf("%s is not an aggregation query", qType.String())
+		return nil
+	}
+}
+
+func sendESRequest(client *http.Client, method string, body []byte, url string, authToken string) map[string]interface{} {
+	req, err := http.NewRequest(method, url, bytes.NewReader(body))
 	if err != nil {
 		log.Fatalf("sendRequest: http.NewRequest ERROR: %v", err)
 	}
@@ -429,103 +545,206 @@ func sendSingleRequest(qType logsQueryTypes, client *http.Client, body []byte, u
 	if err != nil {
 		log.Fatalf("sendRequest: response unmarshal ERROR: %v", err)
 	}
-	return validateAndGetElapsedTime(qType, m, verbose)
+	return m
 }
 
-func initResultMap(numIterations int) map[logsQueryTypes][]float64 {
+func initResultMap(numIterations int, aggs bool, pagination bool) map[logsQueryTypes][]float64 {
 	results := make(map[logsQueryTypes][]float64)
-	results[matchAll] = make([]float64, numIterations)
-	results[matchMultiple] = make([]float64, numIterations)
-	results[matchRange] = make([]float64, numIterations)
-	results[needleInHaystack] = make([]float64, numIterations)
-	results[keyValueQuery] = make([]float64, numIterations)
-	results[freeText] = make([]float64, numIterations)
+	for _, qType := range filterQueryTypes {
+		results[qType] = make([]float64, numIterations)
+	}
 	results[random] = make([]float64, numIterations)
+	results[countQuery] = make([]float64, numIterations)
+	if aggs {
+		for _, qType := range aggQueryTypes {
+			results[qType] = make([]float64, numIterations)
+		}
+	}
+	if pagination {
+		for _, qType := range paginationQueryTypes {
+			results[qType] = make([]float64, numIterations)
+		}
+	}
 	return results
 }
 
-func logQuerySummary(numIterations int, res map[logsQueryTypes][]float64) {
+// allPages has the total time of all pages of pagination queries, whose first page is in res.
+// msearch has the took of each filter query in _msearch batches
+func logQuerySummary(numIterations int, res map[logsQueryTypes][]float64, allPages map[logsQueryTypes][]float64, msearch map[logsQueryTypes][]float64) {
 	log.Infof("-----Query Summary. Completed %d iterations----", numIterations)
 	for qType, qRes := range res {
-		p95, _ := stats.Percentile(qRes, 95)
-		avg, _ := stats.Mean(qRes)
-		max, _ := stats.Max(qRes)
-		min, _ := stats.Min(qRes)
-		log.Infof("QueryType: %s. Min:%+vms, Max:%+vms, Avg:%+vms, P95:%+vms", qType.String(), min, max, avg, p95)
+		logQueryTimes(qType.String(), qRes)
+	}
+	for qType, qRes := range allPages {
+		logQueryTimes(qType.String()+" (all pages)", qRes)
+	}
+	for qType, qRes := range msearch {
+		logQueryTimes(qType.String()+" (msearch)", qRes)
 	}
 }
 
-func StartQuery(dest string, numIterations int, prefix string, continuous bool, verbose bool, randomQueries bool, bearerToken string) {
+// returns the index pattern searched by the queries. indices is a comma separated list of indices that replaces prefix*
+func getSearchTarget(prefix string, indices string) string {
+	if indices == "" {
+		return prefix + "*"
+	}
+	names := strings.Split(indices, ",")
+	for i, name := range names {
+		names[i] = strings.TrimSpace(name)
+	}
+	return strings.Join(names, ",")
+}
+
+func logQueryTimes(name string, qRes []float64) {
+	p95, _ := stats.Percentile(qRes, 95)
+	avg, _ := stats.Mean(qRes)
+	max, _ := stats.Max(qRes)
+	min, _ := stats.Min(qRes)
+	log.Infof("QueryType: %s. Min:%+vms, Max:%+vms, Avg:%+vms, P95:%+vms", name, min, max, avg, p95)
+}
+
+// If aggs, the aggregation queries are also sent each iteration, and if pagination, the pagination queries.
+// The returned map has false for the msearch batch if any of its searches failed, if validateAggs, for every
+// aggregation query type that returned malformed buckets and, if validatePages, for every pagination query type that
+// returned unsorted or repeated documents.
+// Queries search prefix*, or the comma separated list of indices if it is not empty. If msearchSize > 0, that many
+// filter queries are also sent in one _msearch batch each iteration, cycling through the filter query types.
+// If schema is set, random queries filter on its fields instead of the columns of the dynamic-user generator
+func StartQuery(dest string, numIterations int, prefix string, indices string, continuous bool, verbose bool, randomQueries bool, bearerToken string, aggs bool, pagination bool, validateAggs bool, validatePages bool, msearchSize int, schema *ESSchema) map[string]bool {
 	client := http.DefaultClient
 	if numIterations == 0 && !continuous {
 		log.Fatalf("Iterations must be greater than 0")
 	}
+	if msearchSize < 0 {
+		log.Fatalf("msearch size must not be negative")
+	}
 
-	requestStr := fmt.Sprintf("%s/%s*/_search", dest, prefix)
+	target := getSearchTarget(prefix, indices)
+	requestStr := fmt.Sprintf("%s/%s/_search", dest, target)
+	countStr := fmt.Sprintf("%s/%s/_count", dest, target)
+	msearchStr := fmt.Sprintf("%s/_msearch", dest)
 
 	log.Infof("Using destination URL %+s", requestStr)
 	if continuous {
-		runContinuousQueries(client, requestStr, bearerToken)
+		runContinuousQueries(client, dest, requestStr, countStr, msearchStr, target, bearerToken, aggs, pagination, validateAggs, validatePages, msearchSize)
 	}
 
-	results := initResultMap(numIterations)
+	validResult := make(map[string]bool)
+	results := initResultMap(numIterations, aggs, pagination)
+	allPages := make(map[logsQueryTypes][]float64)
+	if pagination {
+		for _, qType := range paginationQueryTypes {
+			allPages[qType] = make([]float64, numIterations)
+		}
+	}
+	msearchTimes := make(map[logsQueryTypes][]float64)
+	if msearchSize > 0 {
+		results[msearchBatch] = make([]float64, numIterations)
+	}
+	faker := gofakeit.NewUnlocked(time.Now().UnixNano())
 	for i := 0; i < numIterations; i++ {
 		if randomQueries {
-			rQuery := getRandomQuery()
+			var rQuery []byte
+			if schema != nil {
+				rQuery = schema.getRandomQuery(faker)
+			} else {
+				rQuery = getRandomQuery()
+			}
+			if verbose {
+				log.Infof("random query: %s", rQuery)
+			}
 			time := sendSingleRequest(random, client, rQuery, requestStr, verbose, bearerToken)
 			results[random][i] = time
 		} else {
-			rawMatchAll := getMatchAllQuery()
-			time := sendSingleRequest(matchAll, client, rawMatchAll, requestStr, verbose, bearerToken)
-			results[matchAll][i] = time
-
-			rawMultiple := getMatchMultipleQuery()
-			time = sendSingleRequest(matchMultiple, client, rawMultiple, requestStr, verbose, bearerToken)
-			results[matchMultiple][i] = time
+			bodies := make(map[logsQueryTypes][]byte)
+			hits := make(map[logsQueryTypes]float64)
+			tooks := make(map[logsQueryTypes]float64)
+			for _, qType := range filterQueryTypes {
+				bodies[qType] = getFilterQuery(qType)
+				tooks[qType], hits[qType] = sendFilterRequest(qType, client, bodies[qType], requestStr, verbose, bearerToken)
+				results[qType][i] = tooks[qType]
+			}
 
-			rawRange := getRangeQuery()
-			time = sendSingleRequest(matchRange, client, rawRange, requestStr, verbose, bearerToken)
-			results[matchRange][i] = time
+			time, count := sendCountRequest(client, countStr, bearerToken)
+			results[countQuery][i] = time
+			log.Infof("count query: [%+v]ms. Count: %v, match all hits: %v", time, count, hits[matchAll])
 
-			rawNeeldQuery := getNeedleInHaystackQuery()
-			time = sendSingleRequest(needleInHaystack, client, rawNeeldQuery, requestStr, verbose, bearerToken)
-			results[needleInHaystack][i] = time
+			if msearchSize > 0 {
+				took, subTooks, valid := sendMsearchBatch(client, msearchStr, target, msearchSize, bodies, hits, tooks, bearerToken)
+				results[msearchBatch][i] = took
+				for qType, qTooks := range subTooks {
+					msearchTimes[qType] = append(msearchTimes[qType], qTooks...)
+				}
+				if !valid {
+					validResult[msearchBatch.String()] = false
+				}
+			}
 
-			sQuery := getSimpleFilter()
-			time = sendSingleRequest(keyValueQuery, client, sQuery, requestStr, verbose, bearerToken)
-			results[keyValueQuery][i] = time
+			if aggs {
+				for _, qType := range aggQueryTypes {
+					time, valid := sendAggRequest(qType, client, requestStr, verbose, bearerToken, validateAggs)
+					results[qType][i] = time
+					if !valid {
+						validResult[qType.String()] = false
+					}
+				}
+			}
 
-			fQuery := getFreeTextSearch()
-			time = sendSingleRequest(freeText, client, fQuery, requestStr, verbose, bearerToken)
-			results[freeText][i] = time
+			if pagination {
+				for _, qType := range paginationQueryTypes {
+					firstPage, all, valid := sendPaginationRequests(qType, client, dest, requestStr, verbose, bearerToken, validatePages)
+					results[qType][i] = firstPage
+					allPages[qType][i] = all
+					if !valid {
+						validResult[qType.String()] = false
+					}
+				}
+			}
 		}
 	}
 
-	logQuerySummary(numIterations, results)
+	logQuerySummary(numIterations, results, allPages, msearchTimes)
+	return validResult
 }
 
 // this will never save time statistics per query and will always log results
-func runContinuousQueries(client *http.Client, requestStr string, bearerToken string) {
+func runContinuousQueries(client *http.Client, dest string, requestStr string, countStr string, msearchStr string, target string, bearerToken string, aggs bool, pagination bool, validateAggs bool, validatePages bool, msearchSize int) {
 	for {
-		rawMatchAll := getMatchAllQuery()
-		_ = sendSingleRequest(matchAll, client, rawMatchAll, requestStr, true, bearerToken)
+		bodies := make(map[logsQueryTypes][]byte)
+		hits := make(map[logsQueryTypes]float64)
+		tooks := make(map[logsQueryTypes]float64)
+		for _, qType := range filterQueryTypes {
+			if qType == needleInHaystack {
+				continue
+			}
+			bodies[qType] = getFilterQuery(qType)
+			tooks[qType], hits[qType] = sendFilterRequest(qType, client, bodies[qType], requestStr, true, bearerToken)
+		}
 
-		rawMultiple := getMatchMultipleQuery()
-		_ = sendSingleRequest(matchMultiple, client, rawMultiple, requestStr, true, bearerToken)
+		time, count := sendCountRequest(client, countStr, bearerToken)
+		log.Infof("count query: [%+v]ms. Count: %v, match all hits: %v", time, count, hits[matchAll])
 
-		rawRange := getRangeQuery()
-		_ = sendSingleRequest(matchRange, client, rawRange, requestStr, true, bearerToken)
+		if msearchSize > 0 {
+			_, _, _ = sendMsearchBatch(client, msearchStr, target, msearchSize, bodies, hits, tooks, bearerToken)
+		}
 
-		sQuery := getSimpleFilter()
-		_ = sendSingleRequest(keyValueQuery, client, sQuery, requestStr, true, bearerToken)
+		if aggs {
+			for _, qType := range aggQueryTypes {
+				_, _ = sendAggRequest(qType, client, requestStr, true, bearerToken, validateAggs)
+			}
+		}
 
-		fQuery := getFreeTextSearch()
-		_ = sendSingleRequest(freeText, client, fQuery, requestStr, true, bearerToken)
+		if pagination {
+			for _, qType := range paginationQueryTypes {
+				_, _, _ = sendPaginationRequests(qType, client, dest, requestStr, true, bearerToken, validatePages)
+			}
+		}
 	}
 }
 
 // Run queries from a csv file. Expects search text, queryStartTime, queryEndTime, indexName,
-// evaluationType, relation, count, and queryLanguage in each row
+// evaluationType, relation, count, and queryLanguage in each row, and optionally record conditions.
+// If a row has record conditions, the records of the query are checked with checkRecords
 // relation is one of "eq", "gt", "lt"
 // if relation is "", count is ignored and no response validation is done
 // The evaluationType should either be "total" to count the number of returned rows, or a string like
@@ -533,7 +752,7 @@ func runContinuousQueries(client *http.Client, requestStr string, bearerToken st
 // start with "group" followed by a colon and the aggregate you want to test, followed by a colon
 // and a colon separated list of keys for the groupby call, or * if aggregates were called without
 // a groupby statement.
-func RunQueryFromFile(dest string, numIterations int, prefix string, continuous, verbose bool, filepath string, bearerToken string) {
+func RunQueryFromFile(dest string, wsDest string, numIterations int, prefix string, continuous, verbose bool, filepath string, bearerToken string) {
 	// open file
 	f, err := os.Open(filepath)
 	if err != nil {
@@ -543,8 +762,12 @@ func RunQueryFromFile(dest string, numIterations int, prefix string, continuous,
 
 	defer f.Close()
 
+	wsURL := getWebsocketURL(wsDest)
+	log.Infof("Using destination URL %+s", wsURL)
 	// read csv values using csv.Reader
 	csvReader := csv.NewReader(f)
+	// the record conditions column is optional
+	csvReader.FieldsPerRecord = -1
 	for {
 		rec, err := csvReader.Read()
 		if err == io.EOF {
@@ -555,8 +778,8 @@ func RunQueryFromFile(dest string, numIterations int, prefix string, continuous,
 			return
 		}
 
-		if len(rec) != 8 {
-			log.Fatalf("RunQueryFromFile: Invalid number of columns in query file: [%v]. Expected 8", rec)
+		if len(rec) != 8 && len(rec) != 9 {
+			log.Fatalf("RunQueryFromFile: Invalid number of columns in query file: [%v]. Expected 8 or 9", rec)
 			return
 		}
 		data := map[string]interface{}{
@@ -570,119 +793,108 @@ func RunQueryFromFile(dest string, numIterations int, prefix string, continuous,
 		evaluationType := rec[4]
 		relation := rec[5]
 		expectedValue := rec[6]
-
-		// create websocket connection
-		conn, _, err := websocket.DefaultDialer.Dial("ws://localhost:5122/api/search/ws", nil)
-		if err != nil {
-			log.Fatalf("RunQueryFromFile: Error connecting to WebSocket server: %v", err)
-			return
+		var conditions []recordCondition
+		if len(rec) == 9 {
+			data["size"] = wsPageSize
+			conditions, err = parseRecordConditions(rec[8])
+			if err != nil {
+				log.Fatalf("RunQueryFromFile: Invalid record conditions for query %v: %v", rec[0], err)
+			}
 		}
-		defer conn.Close()
 
-		err = conn.WriteJSON(data)
+		res, err := sendWebsocketQuery(wsURL, data, verbose)
 		if err != nil {
-			log.Fatalf("Received err message from server: %+v\n", err)
-			break
+			log.Fatalf("RunQueryFromFile: query %v failed: %v", rec[0], err)
 		}
-
-		readEvent := make(map[string]interface{})
-		sTime := time.Now()
-		for {
-			err = conn.ReadJSON(&readEvent)
-			if err != nil {
-				log.Infof("Received error from server: %+v\n", err)
-				break
-			}
-			switch readEvent["state"] {
-			case "RUNNING", "QUERY_UPDATE":
-			case "COMPLETE":
-				for eKey, eValue := range readEvent {
-					if evaluationType == "total" && eKey == "totalMatched" {
-						var hits bool
-						var finalHits float64
-						var err error
-						switch eValue := eValue.(type) {
-						case float64:
-							finalHits = eValue
-							hits, err = verifyInequality(finalHits, relation, expectedValue)
-						case map[string]interface{}:
-							for k, v := range eValue {
-								if k == "value" {
-									var ok bool
-									finalHits, ok = v.(float64)
-									if !ok {
-										log.Fatalf("RunQueryFromFile: Returned total matched is not a float: %v", v)
-									}
-									hits, err = verifyInequality(finalHits, relation, expectedValue)
-
-								}
+		logStreamingMetrics(rec[0], res)
+		if conditions != nil && !logRecordProblems(rec[0], checkRecords(res, conditions)) {
+			log.Fatalf("RunQueryFromFile: Returned records are invalid for query: %v", rec[0])
+		}
+		readEvent := res.complete
+		elapsed := time.Duration(res.elapsed) * time.Millisecond
+		for eKey, eValue := range readEvent {
+			if evaluationType == "total" && eKey == "totalMatched" {
+				var hits bool
+				var finalHits float64
+				var err error
+				switch eValue := eValue.(type) {
+				case float64:
+					finalHits = eValue
+					hits, err = verifyInequality(finalHits, relation, expectedValue)
+				case map[string]interface{}:
+					for k, v := range eValue {
+						if k == "value" {
+							var ok bool
+							finalHits, ok = v.(float64)
+							if !ok {
+								log.Fatalf("RunQueryFromFile: Returned total matched is not a float: %v", v)
 							}
+							hits, err = verifyInequality(finalHits, relation, expectedValue)
+
 						}
-						if err != nil {
-							log.Fatalf("RunQueryFromFile: Error in verifying hits: %v", err)
-						} else if !hits {
-							log.Fatalf("RunQueryFromFile: Actual Hits: %v is not [%s %v] for query:%v", finalHits, rec[6], rec[5], rec[0])
-						} else {
-							log.Infof("RunQueryFromFile: Query %v was succesful. In %+v", rec[0], time.Since(sTime))
-						}
-					} else if strings.HasPrefix(evaluationType, "group") && eKey == "measure" {
-						groupData := strings.Split(evaluationType, ":")
-						groupByList := eValue.([]interface{})
-						validated := false
-
-						for _, v := range groupByList {
-							groupMap := v.(map[string]interface{})
-							groupByValues := groupMap["GroupByValues"].([]interface{})
-							groupByValuesStrs := make([]string, len(groupByValues))
-							for i := range groupByValues {
-								groupByValuesStrs[i] = groupByValues[i].(string)
+					}
+				}
+				if err != nil {
+					log.Fatalf("RunQueryFromFile: Error in verifying hits: %v", err)
+				} else if !hits {
+					log.Fatalf("RunQueryFromFile: Actual Hits: %v is not [%s %v] for query:%v", finalHits, rec[6], rec[5], rec[0])
+				} else {
+					log.Infof("RunQueryFromFile: Query %v was succesful. In %+v", rec[0], elapsed)
+				}
+			} else if strings.HasPrefix(evaluationType, "group") && eKey == "measure" {
+				groupData := strings.Split(evaluationType, ":")
+				groupByList := eValue.([]interface{})
+				validated := false
+
+				for _, v := range groupByList {
+					groupMap := v.(map[string]interface{})
+					groupByValues := groupMap["GroupByValues"].([]interface{})
+					groupByValuesStrs := make([]string, len(groupByValues))
+					for i := range groupByValues {
+						groupByValuesStrs[i] = groupByValues[i].(string)
+					}
+
+					if reflect.DeepEqual(groupByValuesStrs, groupData[2:]) {
+						measureVal := groupMap["MeasureVal"].(map[string]interface{})
+						actualValue, ok := measureVal[groupData[1]].(float64)
+						actualValueIsNumber := true
+						if !ok {
+							// Try converting it to a string and then a float.
+							actualValueStr, ok := measureVal[groupData[1]].(string)
+							if !ok {
+								log.Fatalf("RunQueryFromFile: Returned aggregate is not a string: %v", measureVal[groupData[1]])
 							}
 
-							if reflect.DeepEqual(groupByValuesStrs, groupData[2:]) {
-								measureVal := groupMap["MeasureVal"].(map[string]interface{})
-								actualValue, ok := measureVal[groupData[1]].(float64)
-								actualValueIsNumber := true
-								if !ok {
-									// Try converting it to a string and then a float.
-									actualValueStr, ok := measureVal[groupData[1]].(string)
-									if !ok {
-										log.Fatalf("RunQueryFromFile: Returned aggregate is not a string: %v", measureVal[groupData[1]])
-									}
-
-									var err error
-									actualValue, err = strconv.ParseFloat(actualValueStr, 64)
-
-									if err != nil {
-										actualValueIsNumber = false
-									}
-								}
-
-								if actualValueIsNumber {
-									ok, err = verifyInequality(actualValue, relation, expectedValue)
-								} else {
-									ok, err = verifyInequalityForStr(measureVal[groupData[1]].(string), relation, expectedValue)
-								}
-
-								if err != nil {
-									log.Fatalf("RunQueryFromFile: Error in verifying aggregation: %v", err)
-								} else if !ok {
-									log.Fatalf("RunQueryFromFile: Actual aggregate value: %v is not [%s %v] for query: %v",
-										actualValue, expectedValue, relation, rec[0])
-								} else {
-									validated = true
-								}
+							var err error
+							actualValue, err = strconv.ParseFloat(actualValueStr, 64)
+
+							if err != nil {
+								actualValueIsNumber = false
 							}
 						}
 
-						if validated {
-							log.Infof("RunQueryFromFile: Query %v was succesful. In %+v", rec[0], time.Since(sTime))
+						if actualValueIsNumber {
+							ok, err = verifyInequality(actualValue, relation, expectedValue)
+						} else {
+							ok, err = verifyInequalityForStr(measureVal[groupData[1]].(string), relation, expectedValue)
+						}
+
+						if err != nil {
+							log.Fatalf("RunQueryFromFile: Error in verifying aggregation: %v", err)
+						} else if !ok {
+							log.Fatalf("RunQueryFromFile: Actual aggregate value: %v is not [%s %v] for query: %v",
+								actualValue, expectedValue, relation, rec[0])
 						} else {
-							log.Fatalf("RunQueryFromFile: specified group item not found for query %v", rec[0])
+							validated = true
 						}
 					}
 				}
-			default:
-				log.Infof("Received unknown message from server: %+v\n", readEvent)
+
+				if validated {
+					log.Infof("RunQueryFromFile: Query %v was succesful. In %+v", rec[0], elapsed)
+				} else {
+					log.Fatalf("RunQueryFromFile: specified group item not found for query %v", rec[0])
+				}
 			}
 		}
 	}
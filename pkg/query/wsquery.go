@@ -0,0 +1,356 @@
+package query
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/fasthttp/websocket"
+	"github.com/montanaflynn/stats"
+	log "github.com/sirupsen/logrus"
+)
+
+// query languages of the websocket api
+const (
+	PipeQL   = "Pipe QL"
+	SplunkQL = "Splunk QL"
+	LogQL    = "Log QL"
+	SQL      = "SQL"
+)
+
+var AllQueryLanguages = []string{PipeQL, SplunkQL, LogQL, SQL}
+
+// query types of the ES suite that have an equivalent in every query language
+var wsQueryTypes = []logsQueryTypes{matchAll, keyValueQuery, freeText, matchRange, termsCityAgg}
+
+// same time ranges as the ES queries of each type
+var wsQueryStart = map[logsQueryTypes]string{
+	matchAll:      "now-1h",
+	keyValueQuery: "now-6d",
+	freeText:      "now-1h",
+	matchRange:    "now-1d",
+	termsCityAgg:  "now-1h",
+}
+
+// Log QL needs a stream selector. Every document of the dynamic-user generator has a gender
+const logQLAllStreams = `{gender=~".+"}`
+
+// returns the search text of qType in language. The aggregation counts the documents of each city
+func getLanguageQuery(language string, qType logsQueryTypes, indexName string) string {
+	switch language {
+	case PipeQL:
+		switch qType {
+		case matchAll:
+			return "*"
+		case keyValueQuery:
+			return "state=California"
+		case freeText:
+			return "Representative"
+		case matchRange:
+			return "latency>=10 AND latency<=8925969"
+		case termsCityAgg:
+			return "count(*) groupby city"
+		}
+	case SplunkQL:
+		switch qType {
+		case matchAll:
+			return "*"
+		case keyValueQuery:
+			return "state=California"
+		case freeText:
+			return "Representative"
+		case matchRange:
+			return "latency>=10 latency<=8925969"
+		case termsCityAgg:
+			return "* | stats count by city"
+		}
+	case LogQL:
+		switch qType {
+		case matchAll:
+			return logQLAllStreams
+		case keyValueQuery:
+			return `{state="California"}`
+		case freeText:
+			return logQLAllStreams + ` |= "Representative"`
+		case matchRange:
+			return logQLAllStreams + " | latency >= 10 and latency <= 8925969"
+		case termsCityAgg:
+			return fmt.Sprintf("sum by (city) (count_over_time(%s [1h]))", logQLAllStreams)
+		}
+	case SQL:
+		switch qType {
+		case matchAll:
+			return fmt.Sprintf("SELECT * FROM %s", indexName)
+		case keyValueQuery:
+			return fmt.Sprintf("SELECT * FROM %s WHERE state = 'California'", indexName)
+		case freeText:
+			// SQL has no free text search. Representative only appears in job titles
+			return fmt.Sprintf("SELECT * FROM %s WHERE job_title LIKE '%%Representative%%'", indexName)
+		case matchRange:
+			return fmt.Sprintf("SELECT * FROM %s WHERE latency >= 10 AND latency <= 8925969", indexName)
+		case termsCityAgg:
+			return fmt.Sprintf("SELECT city, COUNT(*) FROM %s GROUP BY city", indexName)
+		}
+	}
+	log.Fatalf("no %s query for %s", language, qType.String())
+	return ""
+}
+
+// returns the conditions the records of qType must satisfy, or "" if any record matches
+func getLanguageRecordConditions(language string, qType logsQueryTypes) string {
+	switch qType {
+	case keyValueQuery:
+		return "state=California"
+	case freeText:
+		if language == SQL {
+			return "job_title~Representative"
+		}
+		return "*~Representative"
+	case matchRange:
+		return "latency>=10;latency<=8925969"
+	default:
+		return ""
+	}
+}
+
+// wsQueryResult is the COMPLETE message of a websocket query
+type wsQueryResult struct {
+	// time from sending the query to receiving COMPLETE
+	elapsed float64
+	// totalMatched, if returned
+	hits    float64
+	hasHits bool
+	// groups of aggregation queries
+	measure []interface{}
+	// records of each QUERY_UPDATE message
+	updates [][]map[string]interface{}
+	// time from sending the query to receiving each QUERY_UPDATE message
+	updateTimes []float64
+	// the COMPLETE message
+	complete map[string]interface{}
+}
+
+// returns the time between consecutive QUERY_UPDATE messages
+func (res *wsQueryResult) updateIntervals() []float64 {
+	intervals := make([]float64, 0, len(res.updateTimes))
+	for i := 1; i < len(res.updateTimes); i++ {
+		intervals = append(intervals, res.updateTimes[i]-res.updateTimes[i-1])
+	}
+	return intervals
+}
+
+// logs the time to the first QUERY_UPDATE, the number of updates, the time between them and the rows of each
+func logStreamingMetrics(name string, res *wsQueryResult) {
+	if len(res.updateTimes) == 0 {
+		log.Infof("%s query: no updates before COMPLETE in [%+v]ms", name, res.elapsed)
+		return
+	}
+	rows := make([]int, len(res.updates))
+	for i, update := range res.updates {
+		rows[i] = len(update)
+	}
+	intervalStr := ""
+	if intervals := res.updateIntervals(); len(intervals) > 0 {
+		min, _ := stats.Min(intervals)
+		avg, _ := stats.Mean(intervals)
+		max, _ := stats.Max(intervals)
+		intervalStr = fmt.Sprintf(" Interval between updates: Min:%+vms, Avg:%+vms, Max:%+vms.", min, avg, max)
+	}
+	log.Infof("%s query: first update in [%+v]ms, COMPLETE in [%+v]ms. Updates: %d.%s Rows per update: %v",
+		name, res.updateTimes[0], res.elapsed, len(res.updateTimes), intervalStr, rows)
+}
+
+// returns the websocket search url of a http destination
+func getWebsocketURL(dest string) string {
+	wsURL := strings.TrimSuffix(dest, "/")
+	if strings.HasPrefix(wsURL, "https://") {
+		wsURL = "wss://" + strings.TrimPrefix(wsURL, "https://")
+	} else if strings.HasPrefix(wsURL, "http://") {
+		wsURL = "ws://" + strings.TrimPrefix(wsURL, "http://")
+	}
+	return wsURL + "/api/search/ws"
+}
+
+// sends a query over a new websocket connection and waits for it to complete
+func sendWebsocketQuery(wsURL string, data map[string]interface{}, verbose bool) (*wsQueryResult, error) {
+	conn, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error connecting to websocket server: %v", err)
+	}
+	defer conn.Close()
+
+	sTime := time.Now()
+	err = conn.WriteJSON(data)
+	if err != nil {
+		return nil, fmt.Errorf("error sending query: %v", err)
+	}
+	updates := make([][]map[string]interface{}, 0)
+	updateTimes := make([]float64, 0)
+	for {
+		readEvent := make(map[string]interface{})
+		err = conn.ReadJSON(&readEvent)
+		if err != nil {
+			return nil, fmt.Errorf("connection closed before the query completed: %v", err)
+		}
+		switch readEvent["state"] {
+		case "RUNNING":
+		case "QUERY_UPDATE":
+			hits, _ := readEvent["hits"].(map[string]interface{})
+			rawRecords, _ := hits["records"].([]interface{})
+			records := make([]map[string]interface{}, 0, len(rawRecords))
+			for _, raw := range rawRecords {
+				if record, ok := raw.(map[string]interface{}); ok {
+					records = append(records, record)
+				}
+			}
+			updates = append(updates, records)
+			updateTimes = append(updateTimes, float64(time.Since(sTime).Milliseconds()))
+		case "COMPLETE":
+			res := &wsQueryResult{elapsed: float64(time.Since(sTime).Milliseconds()), updates: updates, updateTimes: updateTimes, complete: readEvent}
+			switch total := readEvent["totalMatched"].(type) {
+			case float64:
+				res.hits, res.hasHits = total, true
+			case map[string]interface{}:
+				res.hits, res.hasHits = total["value"].(float64)
+			}
+			res.measure, _ = readEvent["measure"].([]interface{})
+			if verbose {
+				log.Infof("COMPLETE message: %+v", readEvent)
+			}
+			return res, nil
+		default:
+			log.Infof("Received unknown message from server: %+v\n", readEvent)
+		}
+	}
+}
+
+// StartWebsocketQuery sends the query types of the ES suite that every query language can express over the websocket
+// api and compares the latencies and hits of each language. Aggregations are compared by their number of groups.
+// If validateRecords, the records of every query that is not an aggregation are checked with checkRecords.
+// The returned map has false for every language and query type that failed
+func StartWebsocketQuery(dest string, numIterations int, prefix string, continuous, verbose bool, languages []string, validateRecords bool) map[string]bool {
+	if numIterations == 0 && !continuous {
+		log.Fatalf("Iterations must be greater than 0")
+	}
+	for _, language := range languages {
+		supported := false
+		for _, l := range AllQueryLanguages {
+			supported = supported || l == language
+		}
+		if !supported {
+			log.Fatalf("Unsupported query language %s. Options=[%s]", language, strings.Join(AllQueryLanguages, ","))
+		}
+	}
+	wsURL := getWebsocketURL(dest)
+	indexName := prefix + "*"
+	log.Infof("Using destination URL %+s", wsURL)
+
+	validResult := make(map[string]bool)
+	results := make(map[string]map[logsQueryTypes][]float64)
+	firstUpdates := make(map[string]map[logsQueryTypes][]float64)
+	intervals := make(map[string]map[logsQueryTypes][]float64)
+	for _, language := range languages {
+		results[language] = make(map[logsQueryTypes][]float64)
+		firstUpdates[language] = make(map[logsQueryTypes][]float64)
+		intervals[language] = make(map[logsQueryTypes][]float64)
+		for _, qType := range wsQueryTypes {
+			log.Infof("%s %s query: %s", language, qType.String(), getLanguageQuery(language, qType, indexName))
+		}
+	}
+	for i := 0; i < numIterations || continuous; i++ {
+		for _, qType := range wsQueryTypes {
+			counts := make(map[string]float64)
+			for _, language := range languages {
+				name := fmt.Sprintf("%s %s", language, qType.String())
+				data := map[string]interface{}{
+					"state":         "query",
+					"searchText":    getLanguageQuery(language, qType, indexName),
+					"startEpoch":    wsQueryStart[qType],
+					"endEpoch":      "now",
+					"indexName":     indexName,
+					"queryLanguage": language,
+					"size":          wsPageSize,
+				}
+				res, err := sendWebsocketQuery(wsURL, data, verbose)
+				if err != nil {
+					log.Errorf("%s query: %v", name, err)
+					validResult[name] = false
+					continue
+				}
+				if validateRecords && qType != termsCityAgg {
+					conditions, err := parseRecordConditions(getLanguageRecordConditions(language, qType))
+					if err != nil {
+						log.Fatalf("invalid record conditions of %s: %v", name, err)
+					}
+					if !logRecordProblems(name, checkRecords(res, conditions)) {
+						validResult[name] = false
+					}
+				}
+				logStreamingMetrics(name, res)
+				if !continuous {
+					results[language][qType] = append(results[language][qType], res.elapsed)
+					if len(res.updateTimes) > 0 {
+						firstUpdates[language][qType] = append(firstUpdates[language][qType], res.updateTimes[0])
+					}
+					intervals[language][qType] = append(intervals[language][qType], res.updateIntervals()...)
+				}
+				if qType == termsCityAgg {
+					counts[language] = float64(len(res.measure))
+					log.Infof("%s query: [%+v]ms. Groups: %d", name, res.elapsed, len(res.measure))
+				} else if res.hasHits {
+					counts[language] = res.hits
+					log.Infof("%s query: [%+v]ms. Hits: %v", name, res.elapsed, res.hits)
+				} else {
+					log.Warnf("%s query: [%+v]ms. Response has no totalMatched", name, res.elapsed)
+				}
+			}
+			logLanguageMismatches(qType, languages, counts)
+		}
+	}
+
+	log.Infof("-----Query Summary. Completed %d iterations----", numIterations)
+	for _, language := range languages {
+		for _, qType := range wsQueryTypes {
+			name := fmt.Sprintf("%s %s", language, qType.String())
+			if qRes, ok := results[language][qType]; ok {
+				logQueryTimes(name, qRes)
+			}
+			if qRes := firstUpdates[language][qType]; len(qRes) > 0 {
+				logQueryTimes(name+" (first update)", qRes)
+			}
+			if qRes := intervals[language][qType]; len(qRes) > 0 {
+				logQueryTimes(name+" (update interval)", qRes)
+			}
+		}
+	}
+	for _, qType := range wsQueryTypes {
+		avgs := make([]string, 0, len(languages))
+		for _, language := range languages {
+			avg, err := stats.Mean(results[language][qType])
+			if err == nil {
+				avgs = append(avgs, fmt.Sprintf("%s=%+vms", language, avg))
+			}
+		}
+		log.Infof("Avg latency of %s: %s", qType.String(), strings.Join(avgs, ", "))
+	}
+	return validResult
+}
+
+// warns if the languages that completed qType returned different hits, or different numbers of groups for aggregations.
+// Documents ingested between the queries can change them
+func logLanguageMismatches(qType logsQueryTypes, languages []string, counts map[string]float64) {
+	first := ""
+	for _, language := range languages {
+		count, ok := counts[language]
+		if !ok {
+			continue
+		}
+		if first == "" {
+			first = language
+			continue
+		}
+		if count != counts[first] {
+			log.Warnf("%s query: %s returned %v, %s returned %v", qType.String(), language, count, first, counts[first])
+		}
+	}
+}
@@ -0,0 +1,184 @@
+package query
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// number of records requested from websocket queries
+const wsPageSize = 100
+
+// operators of record conditions. Longer operators come first so that >= is not parsed as >
+var recordConditionOps = []string{"!=", ">=", "<=", "=", ">", "<", "~"}
+
+// recordCondition is a condition every returned record must satisfy, e.g. state=California or latency>=10.
+// ~ checks that the field contains the value, ignoring case. With ~, the field can be * to check every field
+type recordCondition struct {
+	field string
+	op    string
+	value string
+}
+
+// parses conditions separated by ; e.g. state=California;latency>=10. An empty string has no conditions
+func parseRecordConditions(s string) ([]recordCondition, error) {
+	conditions := make([]recordCondition, 0)
+	for _, raw := range strings.Split(s, ";") {
+		raw = strings.TrimSpace(raw)
+		if raw == "" {
+			continue
+		}
+		// split at the first operator
+		idx, op := -1, ""
+		for _, o := range recordConditionOps {
+			i := strings.Index(raw, o)
+			if i > 0 && (idx == -1 || i < idx) {
+				idx, op = i, o
+			}
+		}
+		if idx == -1 {
+			return nil, fmt.Errorf("invalid record condition %s. Operators=[%s]", raw, strings.Join(recordConditionOps, ","))
+		}
+		c := recordCondition{field: strings.TrimSpace(raw[:idx]), op: op, value: strings.TrimSpace(raw[idx+len(op):])}
+		if c.field == "*" && op != "~" {
+			return nil, fmt.Errorf("invalid record condition %s. Only ~ can be used with *", raw)
+		}
+		if op != "=" && op != "!=" && op != "~" {
+			if _, err := strconv.ParseFloat(c.value, 64); err != nil {
+				return nil, fmt.Errorf("invalid record condition %s. %s needs a number", raw, op)
+			}
+		}
+		conditions = append(conditions, c)
+	}
+	return conditions, nil
+}
+
+func (c recordCondition) matches(record map[string]interface{}) bool {
+	if c.field == "*" {
+		for _, v := range record {
+			if c.contains(v) {
+				return true
+			}
+		}
+		return false
+	}
+	v, ok := record[c.field]
+	if !ok {
+		return false
+	}
+	switch c.op {
+	case "=":
+		return fmt.Sprintf("%v", v) == c.value
+	case "!=":
+		return fmt.Sprintf("%v", v) != c.value
+	case "~":
+		return c.contains(v)
+	}
+	actual, ok := toFloat(v)
+	if !ok {
+		return false
+	}
+	expected, _ := strconv.ParseFloat(c.value, 64)
+	switch c.op {
+	case ">=":
+		return actual >= expected
+	case "<=":
+		return actual <= expected
+	case ">":
+		return actual > expected
+	default:
+		return actual < expected
+	}
+}
+
+func (c recordCondition) contains(v interface{}) bool {
+	s, ok := v.(string)
+	return ok && strings.Contains(strings.ToLower(s), strings.ToLower(c.value))
+}
+
+func (c recordCondition) String() string {
+	return c.field + c.op + c.value
+}
+
+// numbers may be returned as strings
+func toFloat(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case string:
+		f, err := strconv.ParseFloat(n, 64)
+		return f, err == nil
+	default:
+		return 0, false
+	}
+}
+
+// checkRecords returns a description of every problem with the records streamed in the QUERY_UPDATE messages of res:
+// records must have a timestamp and the fields of conditions, satisfy conditions and be sorted by latest timestamp
+// within each update. If the query returned totalMatched, the number of records must be totalMatched, up to wsPageSize
+func checkRecords(res *wsQueryResult, conditions []recordCondition) []string {
+	problems := make([]string, 0)
+	requiredColumns := []string{"timestamp"}
+	for _, c := range conditions {
+		if c.field != "*" && !containsString(requiredColumns, c.field) {
+			requiredColumns = append(requiredColumns, c.field)
+		}
+	}
+
+	numRecords := 0
+	for u, update := range res.updates {
+		prevTs := 0.0
+		for i, record := range update {
+			numRecords++
+			id := fmt.Sprintf("update %d record %d", u, i)
+			missing := false
+			for _, column := range requiredColumns {
+				if _, ok := record[column]; !ok {
+					problems = append(problems, fmt.Sprintf("%s has no column %s: %v", id, column, record))
+					missing = true
+				}
+			}
+			if missing {
+				continue
+			}
+			for _, c := range conditions {
+				if !c.matches(record) {
+					problems = append(problems, fmt.Sprintf("%s does not satisfy %v: %v", id, c, record))
+				}
+			}
+			ts, ok := toFloat(record["timestamp"])
+			if !ok {
+				problems = append(problems, fmt.Sprintf("%s has an invalid timestamp %v", id, record["timestamp"]))
+				continue
+			}
+			if i > 0 && ts > prevTs {
+				problems = append(problems, fmt.Sprintf("%s with timestamp %v is newer than the previous record %v", id, int64(ts), int64(prevTs)))
+			}
+			prevTs = ts
+		}
+	}
+	if res.hasHits {
+		expected := int(res.hits)
+		if expected > wsPageSize {
+			expected = wsPageSize
+		}
+		if numRecords != expected {
+			problems = append(problems, fmt.Sprintf("streamed %d records, expected %d of %v matched", numRecords, expected, res.hits))
+		}
+	}
+	return problems
+}
+
+// logs the problems of name and returns true if there are none
+func logRecordProblems(name string, problems []string) bool {
+	for i, p := range problems {
+		if i == maxLoggedMismatches {
+			log.Errorf("%s query: %d more problems", name, len(problems)-maxLoggedMismatches)
+			break
+		}
+		log.Errorf("%s query: %s", name, p)
+	}
+	return len(problems) == 0
+}
@@ -0,0 +1,132 @@
+package query
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_parseRecordConditions(t *testing.T) {
+	cases := []struct {
+		input    string
+		expected []recordCondition
+	}{
+		{"", []recordCondition{}},
+		{"state=California", []recordCondition{{"state", "=", "California"}}},
+		// two character operators are not split at their first character
+		{"latency>=10", []recordCondition{{"latency", ">=", "10"}}},
+		{"latency<=10.5", []recordCondition{{"latency", "<=", "10.5"}}},
+		{"state!=Texas", []recordCondition{{"state", "!=", "Texas"}}},
+		// the earliest operator splits, so values may contain operators
+		{"url=a>b", []recordCondition{{"url", "=", "a>b"}}},
+		{"msg~x=y", []recordCondition{{"msg", "~", "x=y"}}},
+		{"query!=a<=b", []recordCondition{{"query", "!=", "a<=b"}}},
+		{"*~Representative", []recordCondition{{"*", "~", "Representative"}}},
+		{" state = California ; ;latency<3 ", []recordCondition{{"state", "=", "California"}, {"latency", "<", "3"}}},
+	}
+	for _, tc := range cases {
+		conditions, err := parseRecordConditions(tc.input)
+		assert.NoError(t, err, tc.input)
+		assert.Equal(t, tc.expected, conditions, tc.input)
+	}
+
+	for _, input := range []string{"state", "=California", "*=California", "latency>ten", "state=California;latency"} {
+		_, err := parseRecordConditions(input)
+		assert.Error(t, err, input)
+	}
+}
+
+func Test_recordConditionMatches(t *testing.T) {
+	record := map[string]interface{}{"state": "California", "latency": 10.0, "bytes": "2048", "job_title": "Senior Representative"}
+	cases := []struct {
+		condition string
+		expected  bool
+	}{
+		{"state=California", true},
+		{"state=california", false},
+		{"state!=Texas", true},
+		{"latency=10", true},
+		{"latency>=10", true},
+		{"latency>10", false},
+		{"latency<=10", true},
+		{"latency<10", false},
+		{"bytes>2000", true},
+		{"job_title~representative", true},
+		{"*~REPRESENTATIVE", true},
+		{"*~Texas", false},
+		{"city=Boston", false},
+		{"state>1", false},
+	}
+	for _, tc := range cases {
+		conditions, err := parseRecordConditions(tc.condition)
+		assert.NoError(t, err, tc.condition)
+		assert.Equal(t, tc.expected, conditions[0].matches(record), tc.condition)
+	}
+}
+
+func Test_checkRecords(t *testing.T) {
+	conditions, err := parseRecordConditions("state=California;latency>=10")
+	assert.NoError(t, err)
+	record := func(ts interface{}, state string, latency float64) map[string]interface{} {
+		return map[string]interface{}{"timestamp": ts, "state": state, "latency": latency}
+	}
+	cases := []struct {
+		name     string
+		res      *wsQueryResult
+		problems int
+	}{
+		{
+			name: "valid",
+			res: &wsQueryResult{hits: 3, hasHits: true, updates: [][]map[string]interface{}{
+				{record(3000.0, "California", 10), record(2000.0, "California", 11)},
+				// each update is sorted on its own
+				{record(2500.0, "California", 12)},
+			}},
+		},
+		{
+			name:     "unsorted",
+			res:      &wsQueryResult{updates: [][]map[string]interface{}{{record(1000.0, "California", 10), record(2000.0, "California", 10)}}},
+			problems: 1,
+		},
+		{
+			name:     "condition not satisfied",
+			res:      &wsQueryResult{updates: [][]map[string]interface{}{{record(2000.0, "Texas", 10), record(1000.0, "California", 9)}}},
+			problems: 2,
+		},
+		{
+			name:     "missing columns",
+			res:      &wsQueryResult{updates: [][]map[string]interface{}{{{"state": "California"}}}},
+			problems: 2,
+		},
+		{
+			name:     "invalid timestamp",
+			res:      &wsQueryResult{updates: [][]map[string]interface{}{{record("yesterday", "California", 10)}}},
+			problems: 1,
+		},
+		{
+			name:     "string timestamps",
+			res:      &wsQueryResult{updates: [][]map[string]interface{}{{record("2000", "California", 10), record("1000", "California", 10)}}},
+			problems: 0,
+		},
+		{
+			name:     "fewer records than matched",
+			res:      &wsQueryResult{hits: 5, hasHits: true, updates: [][]map[string]interface{}{{record(1000.0, "California", 10)}}},
+			problems: 1,
+		},
+		{
+			name:     "matched is capped at the page size",
+			res:      &wsQueryResult{hits: 1000, hasHits: true, updates: [][]map[string]interface{}{make([]map[string]interface{}, 0)}},
+			problems: 1,
+		},
+	}
+	for _, tc := range cases {
+		assert.Len(t, checkRecords(tc.res, conditions), tc.problems, tc.name)
+	}
+
+	// wsPageSize records satisfy a query that matched more
+	full := make([]map[string]interface{}, wsPageSize)
+	for i := range full {
+		full[i] = record(float64(wsPageSize-i), "California", 10)
+	}
+	assert.Empty(t, checkRecords(&wsQueryResult{hits: 1000, hasHits: true, updates: [][]map[string]interface{}{full}}, conditions))
+}
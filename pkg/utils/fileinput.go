@@ -0,0 +1,119 @@
+package utils
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// max size of a single line in an input file
+const maxLineSize = 16 * 1024 * 1024
+
+var gzipMagic = []byte{0x1f, 0x8b}
+var zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+
+// resolveInputFiles expands path into a sorted list of files.
+// path can be a single file, a directory (all regular files directly in it) or a glob pattern
+func resolveInputFiles(path string) ([]string, error) {
+	if path == "" {
+		return nil, fmt.Errorf("file path must be specified")
+	}
+	var files []string
+	info, err := os.Stat(path)
+	switch {
+	case err == nil && info.IsDir():
+		entries, err := os.ReadDir(path)
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range entries {
+			if e.Type().IsRegular() {
+				files = append(files, filepath.Join(path, e.Name()))
+			}
+		}
+	case err == nil:
+		files = []string{path}
+	default:
+		files, err = filepath.Glob(path)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no input files found for %s", path)
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// assignFilesToWorker returns the files a worker should read. If there are at least as many files as workers,
+// each worker gets its own subset of files. Otherwise all workers read all files and shardByLine is true,
+// meaning each worker should only keep every numWorkers-th line
+func assignFilesToWorker(files []string, workerIdx, numWorkers int) ([]string, bool) {
+	if numWorkers <= 1 {
+		return files, false
+	}
+	if len(files) < numWorkers {
+		return files, true
+	}
+	assigned := make([]string, 0, len(files)/numWorkers+1)
+	for i := workerIdx; i < len(files); i += numWorkers {
+		assigned = append(assigned, files[i])
+	}
+	return assigned, false
+}
+
+type inputFile struct {
+	io.Reader
+	closers []func() error
+}
+
+func (f *inputFile) Close() error {
+	var firstErr error
+	for _, c := range f.closers {
+		if err := c(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// openInputFile opens fName for reading. gzip and zstd compressed files are detected by their magic bytes
+// and decompressed transparently
+func openInputFile(fName string) (io.ReadCloser, error) {
+	fd, err := os.Open(fName)
+	if err != nil {
+		return nil, err
+	}
+	br := bufio.NewReader(fd)
+	header, err := br.Peek(len(zstdMagic))
+	if err != nil && err != io.EOF && err != bufio.ErrBufferFull {
+		fd.Close()
+		return nil, err
+	}
+	switch {
+	case bytes.HasPrefix(header, gzipMagic):
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			fd.Close()
+			return nil, fmt.Errorf("failed to open gzip file %s: %w", fName, err)
+		}
+		return &inputFile{Reader: gz, closers: []func() error{gz.Close, fd.Close}}, nil
+	case bytes.HasPrefix(header, zstdMagic):
+		zr, err := zstd.NewReader(br)
+		if err != nil {
+			fd.Close()
+			return nil, fmt.Errorf("failed to open zstd file %s: %w", fName, err)
+		}
+		return &inputFile{Reader: zr, closers: []func() error{func() error { zr.Close(); return nil }, fd.Close}}, nil
+	default:
+		return &inputFile{Reader: br, closers: []func() error{fd.Close}}, nil
+	}
+}
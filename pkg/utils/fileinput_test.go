@@ -0,0 +1,50 @@
+package utils
+
+import (
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_assignFilesToWorker(t *testing.T) {
+	files := []string{"a", "b", "c", "d", "e"}
+
+	assigned, byLine := assignFilesToWorker(files, 1, 2)
+	assert.Equal(t, []string{"b", "d"}, assigned)
+	assert.False(t, byLine)
+
+	assigned, byLine = assignFilesToWorker(files, 0, 8)
+	assert.Equal(t, files, assigned)
+	assert.True(t, byLine)
+
+	assigned, byLine = assignFilesToWorker(files, 0, 1)
+	assert.Equal(t, files, assigned)
+	assert.False(t, byLine)
+}
+
+func Test_openInputFileGzip(t *testing.T) {
+	dir := t.TempDir()
+	fName := filepath.Join(dir, "logs.json.gz")
+	fd, err := os.Create(fName)
+	assert.NoError(t, err)
+	gz := gzip.NewWriter(fd)
+	_, err = gz.Write([]byte("{\"a\":1}\n"))
+	assert.NoError(t, err)
+	assert.NoError(t, gz.Close())
+	assert.NoError(t, fd.Close())
+
+	files, err := resolveInputFiles(dir)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{fName}, files)
+
+	rc, err := openInputFile(fName)
+	assert.NoError(t, err)
+	defer rc.Close()
+	raw, err := ioutil.ReadAll(rc)
+	assert.NoError(t, err)
+	assert.Equal(t, "{\"a\":1}\n", string(raw))
+}
@@ -0,0 +1,319 @@
+package utils
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/brianvoe/gofakeit/v6"
+	log "github.com/sirupsen/logrus"
+)
+
+// Types of incidents that can be injected into generated logs
+const (
+	// a fraction of the events where field is value get an error status in targetField. Events that have a level
+	// and a stream also get level=error and stream=stderr
+	IncidentErrorRate = "error_rate"
+	// a fraction of the events where field is value get targetField multiplied by magnitude
+	IncidentLatency = "latency"
+	// a fraction of the events where field is value get template in targetField. Placeholders are replaced like the
+	// k8s log messages
+	IncidentNewTemplate = "new_template"
+	// events where field is value are not sent
+	IncidentSilence = "silence"
+)
+
+// max number of events to skip for a single silenced event before giving up
+const maxSilencedRetries = 1_000
+
+// Incident is a single anomaly injected during [start, start+duration) after the first event is generated
+type Incident struct {
+	Name        string  `json:"name"`
+	Type        string  `json:"type"`
+	Start       string  `json:"start"`
+	Duration    string  `json:"duration"`
+	Field       string  `json:"field,omitempty"`
+	Value       string  `json:"value,omitempty"`
+	TargetField string  `json:"targetField,omitempty"`
+	Rate        float64 `json:"rate,omitempty"`
+	Magnitude   float64 `json:"magnitude,omitempty"`
+	Template    string  `json:"template,omitempty"`
+
+	start    time.Duration
+	duration time.Duration
+
+	injected      uint64
+	firstInjected int64
+	lastInjected  int64
+}
+
+// manifest entry for a single incident. Times are in epoch millis
+type incidentManifest struct {
+	*Incident
+	WindowStart    int64  `json:"windowStart"`
+	WindowEnd      int64  `json:"windowEnd"`
+	FirstInjected  int64  `json:"firstInjected"`
+	LastInjected   int64  `json:"lastInjected"`
+	InjectedEvents uint64 `json:"injectedEvents"`
+}
+
+// IncidentInjector holds the incidents shared by all generators and records what was injected
+type IncidentInjector struct {
+	incidents    []*Incident
+	manifestPath string
+
+	startOnce    sync.Once
+	runStart     time.Time
+	defaultsOnce sync.Once
+}
+
+// InitIncidentInjector reads a json list of incidents from configPath.
+// A manifest of what was injected will be written to manifestPath
+func InitIncidentInjector(configPath, manifestPath string) (*IncidentInjector, error) {
+	raw, err := ioutil.ReadFile(configPath)
+	if err != nil {
+		return nil, err
+	}
+	incidents := make([]*Incident, 0)
+	err = json.Unmarshal(raw, &incidents)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse incidents from %s: %w", configPath, err)
+	}
+	for i, inc := range incidents {
+		err = inc.validate()
+		if err != nil {
+			return nil, fmt.Errorf("invalid incident %d in %s: %w", i, configPath, err)
+		}
+	}
+	return &IncidentInjector{
+		incidents:    incidents,
+		manifestPath: manifestPath,
+	}, nil
+}
+
+func (inc *Incident) validate() error {
+	var err error
+	inc.start, err = time.ParseDuration(inc.Start)
+	if err != nil {
+		return fmt.Errorf("failed to parse start %s: %w", inc.Start, err)
+	}
+	inc.duration, err = time.ParseDuration(inc.Duration)
+	if err != nil {
+		return fmt.Errorf("failed to parse duration %s: %w", inc.Duration, err)
+	}
+	if inc.Rate == 0 {
+		inc.Rate = 1
+	}
+	if inc.Name == "" && inc.Field == "" {
+		inc.Name = inc.Type
+	} else if inc.Name == "" {
+		inc.Name = fmt.Sprintf("%s %s=%s", inc.Type, inc.Field, inc.Value)
+	}
+	switch inc.Type {
+	case IncidentErrorRate:
+		// the default depends on the generator, see setGeneratorDefaults
+	case IncidentLatency:
+		if inc.TargetField == "" {
+			inc.TargetField = "latency"
+		}
+		if inc.Magnitude == 0 {
+			return fmt.Errorf("latency incident needs a magnitude")
+		}
+	case IncidentNewTemplate:
+		if inc.TargetField == "" {
+			inc.TargetField = "msg"
+		}
+		if inc.Template == "" {
+			return fmt.Errorf("new_template incident needs a template")
+		}
+	case IncidentSilence:
+		if inc.Field == "" {
+			return fmt.Errorf("silence incident needs a field")
+		}
+	default:
+		return fmt.Errorf("unsupported incident type %s. Options=[%s,%s,%s,%s]", inc.Type,
+			IncidentErrorRate, IncidentLatency, IncidentNewTemplate, IncidentSilence)
+	}
+	return nil
+}
+
+// sets the default targetField of error_rate incidents to the status field of gen.
+// All generators of an injector are of the same type, so this only runs for the first one
+func (ii *IncidentInjector) setGeneratorDefaults(gen Generator) {
+	ii.defaultsOnce.Do(func() {
+		statusField := "http_status"
+		if _, ok := gen.(*K8sGenerator); ok {
+			statusField = "httpStatus"
+		}
+		for _, inc := range ii.incidents {
+			if inc.Type == IncidentErrorRate && inc.TargetField == "" {
+				inc.TargetField = statusField
+			}
+		}
+	})
+}
+
+func (ii *IncidentInjector) start() time.Time {
+	ii.startOnce.Do(func() {
+		ii.runStart = time.Now()
+		log.Infof("Starting incident injection. %d incidents will be injected", len(ii.incidents))
+	})
+	return ii.runStart
+}
+
+func (inc *Incident) isActive(elapsed time.Duration) bool {
+	return elapsed >= inc.start && elapsed < inc.start+inc.duration
+}
+
+// returns whether m is affected by the incident. Incidents without a field affect all events
+func (inc *Incident) matches(m map[string]interface{}) bool {
+	return inc.Field == "" || fmt.Sprintf("%v", m[inc.Field]) == inc.Value
+}
+
+func (inc *Incident) recordInjection(now time.Time) {
+	ms := now.UnixMilli()
+	atomic.AddUint64(&inc.injected, 1)
+	atomic.CompareAndSwapInt64(&inc.firstInjected, 0, ms)
+	atomic.StoreInt64(&inc.lastInjected, ms)
+}
+
+// WriteManifest writes what has been injected so far. It is safe to call while generators are running
+func (ii *IncidentInjector) WriteManifest() error {
+	runStart := ii.start()
+	entries := make([]incidentManifest, len(ii.incidents))
+	for i, inc := range ii.incidents {
+		entries[i] = incidentManifest{
+			Incident:       inc,
+			WindowStart:    runStart.Add(inc.start).UnixMilli(),
+			WindowEnd:      runStart.Add(inc.start + inc.duration).UnixMilli(),
+			FirstInjected:  atomic.LoadInt64(&inc.firstInjected),
+			LastInjected:   atomic.LoadInt64(&inc.lastInjected),
+			InjectedEvents: atomic.LoadUint64(&inc.injected),
+		}
+	}
+	manifest := map[string]interface{}{
+		"runStart":  runStart.UnixMilli(),
+		"incidents": entries,
+	}
+	raw, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(ii.manifestPath, raw, os.FileMode(0644))
+}
+
+// InjectingGenerator injects the incidents of an IncidentInjector into the logs of another generator
+type InjectingGenerator struct {
+	gen      Generator
+	injector *IncidentInjector
+	faker    *gofakeit.Faker
+}
+
+func InitInjectingGenerator(gen Generator, injector *IncidentInjector, seed int64) *InjectingGenerator {
+	injector.setGeneratorDefaults(gen)
+	return &InjectingGenerator{
+		gen:      gen,
+		injector: injector,
+		faker:    gofakeit.NewUnlocked(seed),
+	}
+}
+
+func (ig *InjectingGenerator) Init(fName ...string) error {
+	return ig.gen.Init(fName...)
+}
+
+func (ig *InjectingGenerator) GetLogLine() ([]byte, error) {
+	m, err := ig.GetRawLog()
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(m)
+}
+
+func (ig *InjectingGenerator) GetRawLog() (map[string]interface{}, error) {
+	runStart := ig.injector.start()
+	now := time.Now()
+	elapsed := now.Sub(runStart)
+	m, err := ig.nextNotSilenced(now, elapsed)
+	if err != nil {
+		return nil, err
+	}
+	copied := false
+	for _, inc := range ig.injector.incidents {
+		// only events that already match are changed, so the volume of field=value and the fields derived from it
+		// stay the same
+		if inc.Type == IncidentSilence || !inc.isActive(elapsed) || !inc.matches(m) || ig.faker.Rand.Float64() >= inc.Rate {
+			continue
+		}
+		if !copied {
+			// generators reuse their map, so fields added here must not leak into the next event
+			m = copyMap(m)
+			copied = true
+		}
+		switch inc.Type {
+		case IncidentErrorRate:
+			m[inc.TargetField] = 500
+			// the k8s generator derives level and stream from the status
+			if _, ok := m["level"]; ok {
+				m["level"] = "error"
+			}
+			if _, ok := m["stream"]; ok {
+				m["stream"] = "stderr"
+			}
+		case IncidentLatency:
+			m[inc.TargetField] = toFloat(m[inc.TargetField]) * inc.Magnitude
+		case IncidentNewTemplate:
+			m[inc.TargetField] = replacePlaceholders(ig.faker, inc.Template)
+		}
+		inc.recordInjection(now)
+	}
+	return m, nil
+}
+
+// skips events that match an active silence incident
+func (ig *InjectingGenerator) nextNotSilenced(now time.Time, elapsed time.Duration) (map[string]interface{}, error) {
+	for i := 0; i < maxSilencedRetries; i++ {
+		m, err := ig.gen.GetRawLog()
+		if err != nil {
+			return nil, err
+		}
+		silenced := false
+		for _, inc := range ig.injector.incidents {
+			if inc.Type == IncidentSilence && inc.isActive(elapsed) && inc.matches(m) {
+				inc.recordInjection(now)
+				silenced = true
+				break
+			}
+		}
+		if !silenced {
+			return m, nil
+		}
+	}
+	return nil, fmt.Errorf("more than %d consecutive events were silenced", maxSilencedRetries)
+}
+
+func copyMap(m map[string]interface{}) map[string]interface{} {
+	final := make(map[string]interface{}, len(m)+2)
+	for k, v := range m {
+		final[k] = v
+	}
+	return final
+}
+
+func toFloat(v interface{}) float64 {
+	switch v := v.(type) {
+	case int:
+		return float64(v)
+	case int64:
+		return float64(v)
+	case uint64:
+		return float64(v)
+	case float64:
+		return v
+	default:
+		return 0
+	}
+}
@@ -0,0 +1,75 @@
+package utils
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_errorRateFieldsPerGenerator(t *testing.T) {
+	cases := []struct {
+		name     string
+		gen      Generator
+		expected map[string]interface{}
+		absent   []string
+	}{
+		{
+			name:     "dynamic-user",
+			gen:      InitDynamicUserGenerator(false, 42),
+			expected: map[string]interface{}{"http_status": 500},
+			absent:   []string{"httpStatus", "level", "stream"},
+		},
+		{
+			name:     "k8s",
+			gen:      InitK8sGenerator(false, DeriveSeed(42, 0), 42),
+			expected: map[string]interface{}{"httpStatus": 500, "level": "error", "stream": "stderr"},
+			absent:   []string{"http_status"},
+		},
+	}
+	for _, tc := range cases {
+		inc := &Incident{Type: IncidentErrorRate, Start: "0s", Duration: "1h"}
+		assert.NoError(t, inc.validate(), tc.name)
+		injector := &IncidentInjector{incidents: []*Incident{inc}}
+		ig := InitInjectingGenerator(tc.gen, injector, 1)
+		assert.NoError(t, ig.Init(), tc.name)
+		for i := 0; i < 100; i++ {
+			m, err := ig.GetRawLog()
+			assert.NoError(t, err, tc.name)
+			for k, v := range tc.expected {
+				assert.Equal(t, v, m[k], "%s: %s", tc.name, k)
+			}
+			for _, k := range tc.absent {
+				assert.NotContains(t, m, k, tc.name)
+			}
+		}
+		assert.Equal(t, uint64(100), inc.injected, tc.name)
+	}
+}
+
+func Test_incidentsOnlyAffectMatchingEvents(t *testing.T) {
+	inc := &Incident{Type: IncidentLatency, Start: "0s", Duration: "1h", Field: "group", Value: "group 1", Magnitude: 1000}
+	assert.NoError(t, inc.validate())
+	injector := &IncidentInjector{incidents: []*Incident{inc}}
+	ig := InitInjectingGenerator(InitDynamicUserGenerator(false, 42), injector, 1)
+	assert.NoError(t, ig.Init())
+	plain := InitDynamicUserGenerator(false, 42)
+	assert.NoError(t, plain.Init())
+
+	matching := uint64(0)
+	for i := 0; i < 300; i++ {
+		m, err := ig.GetRawLog()
+		assert.NoError(t, err)
+		expected, err := plain.GetRawLog()
+		assert.NoError(t, err)
+		// the injected field keeps the distribution of the generator
+		assert.Equal(t, expected["group"], m["group"])
+		if m["group"] == "group 1" {
+			matching++
+			assert.Equal(t, toFloat(expected["latency"])*1000, m["latency"])
+		} else {
+			assert.Equal(t, expected["latency"], m["latency"])
+		}
+	}
+	assert.NotZero(t, matching)
+	assert.Equal(t, matching, inc.injected)
+}
@@ -0,0 +1,269 @@
+package utils
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/brianvoe/gofakeit/v6"
+)
+
+// sizes of the generated k8s topology
+const (
+	k8sNumClusters         = 2
+	k8sNodesPerCluster     = 10
+	k8sDeploysPerNamespace = 3
+	k8sMinReplicas         = 2
+	k8sMaxReplicas         = 5
+)
+
+// pods restart and are replaced in churn windows. These are windows of k8sChurnWindow of the event timestamps, or
+// of k8sEventsPerChurnWindow events of a worker if events have no timestamp. In every window, each pod restarts its
+// containers with k8sRestartProbability and is replaced by a new pod with k8sChurnProbability
+const (
+	k8sChurnWindow          = time.Minute
+	k8sEventsPerChurnWindow = 10_000
+	k8sRestartProbability   = 0.01
+	k8sChurnProbability     = 0.005
+)
+
+var k8sRegions = []string{"us-east-1", "us-west-2", "eu-west-1", "ap-south-1"}
+var k8sNamespaces = []string{"default", "kube-system", "payments", "checkout", "search", "monitoring"}
+var k8sApps = []string{"api-gateway", "auth", "cart", "catalog", "frontend", "inventory", "ledger", "notifier",
+	"orders", "pricing", "recommender", "shipping", "indexer", "query", "scheduler", "worker"}
+var k8sSidecars = []string{"istio-proxy", "envoy", "log-shipper"}
+
+type k8sNode struct {
+	name string
+	ip   string
+	zone string
+}
+
+type k8sCluster struct {
+	name   string
+	region string
+	nodes  []*k8sNode
+}
+
+type k8sContainer struct {
+	name  string
+	image string
+	hash  string
+	port  int
+}
+
+type k8sDeployment struct {
+	cluster    *k8sCluster
+	namespace  string
+	name       string
+	version    string
+	containers []*k8sContainer
+}
+
+type k8sPod struct {
+	deploy       *k8sDeployment
+	name         string
+	uid          string
+	ip           string
+	node         *k8sNode
+	podHash      string
+	seed         int64
+	dockerIds    []string
+	restartCount int
+}
+
+// k8sPodSlot is a replica of a deployment, filled by a new pod every time the previous one is replaced.
+// start is the churn window the current pod was created in and window the last window the pod was computed for
+type k8sPodSlot struct {
+	deploy  *k8sDeployment
+	podHash string
+	hash    uint64
+	start   int64
+	window  int64
+	pod     *k8sPod
+}
+
+// a fixed set of clusters, nodes, namespaces, deployments and pod slots. Pods restart and are replaced over time,
+// but every event is consistent with the current state of the topology.
+// The pods and their restarts only depend on the topology seed and the time, so all generators with the same
+// topology seed agree on them
+type k8sTopology struct {
+	// builds the clusters, nodes and deployments
+	f        *gofakeit.Faker
+	clusters []*k8sCluster
+	slots    []*k8sPodSlot
+}
+
+func newK8sTopology(topologySeed int64) *k8sTopology {
+	topologyF := gofakeit.NewUnlocked(topologySeed)
+	t := &k8sTopology{f: topologyF}
+	for c := 0; c < k8sNumClusters; c++ {
+		cluster := &k8sCluster{
+			name:   fmt.Sprintf("k8s-cluster-%d", c),
+			region: k8sRegions[c%len(k8sRegions)],
+		}
+		for n := 0; n < k8sNodesPerCluster; n++ {
+			ip := fmt.Sprintf("10.%d.%d.%d", c, topologyF.Number(0, 255), topologyF.Number(1, 254))
+			cluster.nodes = append(cluster.nodes, &k8sNode{
+				name: fmt.Sprintf("ip-%s.%s.compute.internal", strings.ReplaceAll(ip, ".", "-"), cluster.region),
+				ip:   ip,
+				zone: fmt.Sprintf("%s%c", cluster.region, 'a'+n%3),
+			})
+		}
+		t.clusters = append(t.clusters, cluster)
+
+		for _, ns := range k8sNamespaces {
+			apps := make([]string, len(k8sApps))
+			copy(apps, k8sApps)
+			topologyF.ShuffleStrings(apps)
+			for d := 0; d < k8sDeploysPerNamespace; d++ {
+				deploy := t.newDeployment(cluster, ns, apps[d])
+				replicas := topologyF.Number(k8sMinReplicas, k8sMaxReplicas)
+				podHash := randomHex(topologyF, 5)
+				for r := 0; r < replicas; r++ {
+					t.slots = append(t.slots, &k8sPodSlot{
+						deploy:  deploy,
+						podHash: podHash,
+						hash:    uint64(DeriveSeed(topologySeed, len(t.slots))),
+					})
+				}
+			}
+		}
+	}
+	return t
+}
+
+func (t *k8sTopology) newDeployment(cluster *k8sCluster, ns string, app string) *k8sDeployment {
+	version := fmt.Sprintf("%d.%d.%d", t.f.Number(1, 3), t.f.Number(0, 20), t.f.Number(0, 9))
+	deploy := &k8sDeployment{
+		cluster:   cluster,
+		namespace: ns,
+		name:      app,
+		version:   version,
+	}
+	deploy.containers = append(deploy.containers, t.newContainer(app, fmt.Sprintf("registry.example.com/%s/%s:%s", ns, app, version)))
+	if t.f.Number(0, 1) == 1 {
+		sidecar := k8sSidecars[t.f.Number(0, len(k8sSidecars)-1)]
+		deploy.containers = append(deploy.containers, t.newContainer(sidecar, fmt.Sprintf("docker.io/library/%s:1.%d", sidecar, t.f.Number(0, 20))))
+	}
+	return deploy
+}
+
+func (t *k8sTopology) newContainer(name, image string) *k8sContainer {
+	return &k8sContainer{
+		name:  name,
+		image: image,
+		hash:  fmt.Sprintf("%s@sha256:%s", strings.Split(image, ":")[0], randomHex(t.f, 32)),
+		port:  t.f.Number(1024, 65535),
+	}
+}
+
+// the pod of slot that was created in churn window start
+func newPod(slot *k8sPodSlot, start int64) *k8sPod {
+	deploy := slot.deploy
+	seed := DeriveSeed(int64(slot.hash), int(start))
+	f := gofakeit.NewUnlocked(seed)
+	return &k8sPod{
+		deploy:  deploy,
+		name:    fmt.Sprintf("%s-%s-%s", deploy.name, slot.podHash, strings.ToLower(f.LetterN(5))),
+		uid:     f.UUID(),
+		ip:      fmt.Sprintf("100.%d.%d.%d", 64+f.Number(0, 63), f.Number(0, 255), f.Number(1, 254)),
+		node:    deploy.cluster.nodes[f.Number(0, len(deploy.cluster.nodes)-1)],
+		podHash: slot.podHash,
+		seed:    seed,
+	}
+}
+
+// restarted containers get new ids
+func (pod *k8sPod) setRestartCount(restartCount int) {
+	f := gofakeit.NewUnlocked(DeriveSeed(pod.seed, restartCount))
+	pod.restartCount = restartCount
+	pod.dockerIds = make([]string, len(pod.deploy.containers))
+	for i := range pod.dockerIds {
+		pod.dockerIds[i] = randomHex(f, 32)
+	}
+}
+
+func randomHex(f *gofakeit.Faker, nBytes int) string {
+	var sb strings.Builder
+	for i := 0; i < nBytes; i++ {
+		sb.WriteString(fmt.Sprintf("%02x", f.Number(0, 255)))
+	}
+	return sb.String()
+}
+
+// nextPod picks the pod for the next event with f and returns its state in churn window
+func (t *k8sTopology) nextPod(f *gofakeit.Faker, window int64) *k8sPod {
+	slot := t.slots[f.Number(0, len(t.slots)-1)]
+	return slot.podAt(window)
+}
+
+// returns the pod of the slot in churn window. The pod is replaced in the windows where the churn hash of the slot is
+// below k8sChurnProbability, and restarts in the following windows where its restart hash is below k8sRestartProbability
+func (slot *k8sPodSlot) podAt(window int64) *k8sPod {
+	if slot.pod != nil && window == slot.window {
+		return slot.pod
+	}
+	restartHash := uint64(DeriveSeed(int64(slot.hash), -1))
+	if slot.pod == nil || window < slot.window || window-slot.window > maxChurnLookback {
+		// find the window the current pod was created in and count its restarts since then
+		slot.start = window - maxChurnLookback
+		for start := window; start > window-maxChurnLookback; start-- {
+			if hashUnit(slot.hash, start) < k8sChurnProbability {
+				slot.start = start
+				break
+			}
+		}
+		slot.pod = newPod(slot, slot.start)
+		restarts := 0
+		for w := slot.start + 1; w <= window; w++ {
+			if hashUnit(restartHash, w) < k8sRestartProbability {
+				restarts++
+			}
+		}
+		slot.pod.setRestartCount(restarts)
+		slot.window = window
+		return slot.pod
+	}
+	for w := slot.window + 1; w <= window; w++ {
+		switch {
+		case hashUnit(slot.hash, w) < k8sChurnProbability:
+			slot.start = w
+			slot.pod = newPod(slot, w)
+			slot.pod.setRestartCount(0)
+		case hashUnit(restartHash, w) < k8sRestartProbability:
+			slot.pod.setRestartCount(slot.pod.restartCount + 1)
+		}
+	}
+	slot.window = window
+	return slot.pod
+}
+
+// fills m with the metadata fluent bit's kubernetes filter would attach to a log line of container cIdx in pod
+func (pod *k8sPod) addMetadata(m map[string]interface{}, cIdx int) {
+	deploy := pod.deploy
+	container := deploy.containers[cIdx]
+	m["cluster"] = deploy.cluster.name
+	m["Region"] = deploy.cluster.region
+	m["Az"] = pod.node.zone
+	m["hostname"] = pod.node.name
+	m["IPv4Address"] = pod.ip
+	m["Port"] = container.port
+	m["DomainName"] = fmt.Sprintf("%s.%s.svc.cluster.local", deploy.name, deploy.namespace)
+	m["kubernetes"] = map[string]interface{}{
+		"pod_name":        pod.name,
+		"namespace_name":  deploy.namespace,
+		"pod_id":          pod.uid,
+		"host":            pod.node.name,
+		"container_name":  container.name,
+		"docker_id":       pod.dockerIds[cIdx],
+		"container_hash":  container.hash,
+		"container_image": container.image,
+		"labels": map[string]interface{}{
+			"app":               deploy.name,
+			"pod-template-hash": pod.podHash,
+			"version":           deploy.version,
+			"restart_count":     pod.restartCount,
+		},
+	}
+}
@@ -0,0 +1,92 @@
+package utils
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// Lifetime distributions of churned series
+const (
+	// every interval, each series is replaced with probability ChurnFraction, so lifetimes are exponential
+	ChurnExponential = "exponential"
+	// every series lives for exactly interval/ChurnFraction, starting at a random phase
+	ChurnFixed = "fixed"
+)
+
+// max number of churn windows to look back for the start of a k8s pod
+const maxChurnLookback = 100_000
+
+// exponential lifetimes are drawn in epochs of this many mean lifetimes. Each series starts a new lifetime at the start
+// of its epoch, so finding the lifetime of a window only takes a few draws however small the churn fraction is
+const churnEpochLifetimes = 8
+
+func (mc *MetricsConfig) validateChurn() error {
+	if mc.ChurnInterval <= 0 {
+		return nil
+	}
+	if len(mc.Tags) == 0 {
+		return fmt.Errorf("series churn requires a tag schema")
+	}
+	if mc.ChurnInterval%time.Second != 0 {
+		return fmt.Errorf("churn interval must be a whole number of seconds, got %+v", mc.ChurnInterval)
+	}
+	if mc.ChurnFraction <= 0 || mc.ChurnFraction > 1 {
+		return fmt.Errorf("churn fraction must be in (0, 1], got %+v", mc.ChurnFraction)
+	}
+	if mc.ChurnLifetime == "" {
+		mc.ChurnLifetime = ChurnExponential
+	}
+	if mc.ChurnLifetime != ChurnExponential && mc.ChurnLifetime != ChurnFixed {
+		return fmt.Errorf("unsupported churn lifetime %s. Options=[%s,%s]", mc.ChurnLifetime, ChurnExponential, ChurnFixed)
+	}
+	if mc.ChurnTag == "" {
+		mc.ChurnTag = mc.Tags[len(mc.Tags)-1].Key
+	}
+	for _, tag := range mc.Tags {
+		if tag.Key == mc.ChurnTag {
+			return nil
+		}
+	}
+	return fmt.Errorf("churn tag %s is not in the tag schema", mc.ChurnTag)
+}
+
+// churn replaces the value of the churn tag with one that is unique to the current lifetime of the series at ts.
+// All metrics with the same tags are replaced at the same time, like all series of a pod
+func (mc *MetricsConfig) churn(tags map[string]interface{}, ts int64) {
+	if mc.ChurnInterval <= 0 {
+		return
+	}
+	lifetimeStart := mc.lifetimeStart(hashString(seriesKey("", tags)), floorDiv(ts, int64(mc.ChurnInterval.Seconds())))
+	tags[mc.ChurnTag] = fmt.Sprintf("%v-%d", tags[mc.ChurnTag], lifetimeStart)
+}
+
+// returns the churn interval in which the series with hash h that is alive in interval window was created
+func (mc *MetricsConfig) lifetimeStart(h uint64, window int64) int64 {
+	switch mc.ChurnLifetime {
+	case ChurnFixed:
+		lifetime := int64(1/mc.ChurnFraction + 0.5)
+		phase := int64(hashUnit(h, 0) * float64(lifetime))
+		return window - (window + phase - floorDiv(window+phase, lifetime)*lifetime)
+	default:
+		if mc.ChurnFraction >= 1 {
+			return window
+		}
+		// epochs of each series start at a different phase, so the forced replacements at their starts are spread
+		// out. The fraction replaced within an epoch is lowered by one per epoch to keep the total at ChurnFraction
+		epochLen := int64(math.Ceil(churnEpochLifetimes / mc.ChurnFraction))
+		phase := int64(hashUnit(h, -1) * float64(epochLen))
+		epoch := floorDiv(window+phase, epochLen)
+		epochHash := uint64(DeriveSeed(int64(h), int(epoch)))
+		logKeep := math.Log(1 - (mc.ChurnFraction - 1/float64(epochLen)))
+		// lifetimes within the epoch are geometric
+		start := epoch*epochLen - phase
+		for i := int64(0); ; i++ {
+			next := start + 1 + int64(math.Log(1-hashUnit(epochHash, i))/logKeep)
+			if next > window {
+				return start
+			}
+			start = next
+		}
+	}
+}
@@ -0,0 +1,214 @@
+package utils
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// TagSchema is a tag key and the number of different values it takes
+type TagSchema struct {
+	Key         string
+	Cardinality uint64
+}
+
+// MetricsConfig configures the series sent by MetricsGenerator
+type MetricsConfig struct {
+	NumMetrics int
+	// tag keys of every series. If empty, random tags are generated and the number of series is not known up front
+	Tags []TagSchema
+	// if set, every series is sent in order instead of picking random ones. Requires Tags
+	CycleSeries bool
+	// one of ValueCounter, ValueGauge, ValueSine, ValueStep, ValueHistogram or ValueMixed
+	ValueModel string
+	// if set, every series is sent once per Interval from Backfill ago until now, instead of at the current time. Requires Tags
+	Backfill time.Duration
+	Interval time.Duration
+	// if set, ChurnFraction of the series are replaced every ChurnInterval by giving ChurnTag a new value.
+	// ChurnLifetime is ChurnExponential or ChurnFixed. ChurnTag defaults to the last tag of the schema
+	ChurnInterval time.Duration
+	ChurnFraction float64
+	ChurnLifetime string
+	ChurnTag      string
+	// CounterExact or CounterHLL. HLLPrecision is only used by CounterHLL
+	SeriesCounter string
+	HLLPrecision  int
+
+	// metric and histogram bucket of each series with the same tags. Set by Init
+	slots []seriesSlot
+	// range of timestamps to backfill in epoch seconds, aligned to Interval. Set by Init
+	backfillStart int64
+	backfillEnd   int64
+}
+
+type seriesSlot struct {
+	metricIdx int
+	// index into histogramBuckets, -1 if the metric is not a histogram
+	bucket int
+}
+
+// ParseTagSchema parses a list of key=cardinality pairs, e.g. host=10,pod=50,region=3
+func ParseTagSchema(spec string) ([]TagSchema, error) {
+	tags := make([]TagSchema, 0)
+	if strings.TrimSpace(spec) == "" {
+		return tags, nil
+	}
+	seen := make(map[string]bool)
+	for _, kv := range strings.Split(spec, ",") {
+		parts := strings.SplitN(strings.TrimSpace(kv), "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, fmt.Errorf("invalid tag %s. Expected key=cardinality", kv)
+		}
+		if seen[parts[0]] {
+			return nil, fmt.Errorf("tag key %s is repeated", parts[0])
+		}
+		seen[parts[0]] = true
+		cardinality, err := strconv.ParseUint(parts[1], 10, 64)
+		if err != nil || cardinality == 0 {
+			return nil, fmt.Errorf("invalid cardinality %s for tag key %s", parts[1], parts[0])
+		}
+		tags = append(tags, TagSchema{Key: parts[0], Cardinality: cardinality})
+	}
+	return tags, nil
+}
+
+// Init validates the config and computes the series layout. It must be called before the config is used
+func (mc *MetricsConfig) Init() error {
+	if mc.NumMetrics <= 0 {
+		return fmt.Errorf("number of metrics must be positive, got %d", mc.NumMetrics)
+	}
+	if mc.ValueModel == "" {
+		mc.ValueModel = ValueMixed
+	}
+	if mc.ValueModel != ValueMixed && mc.modelIdx(mc.ValueModel) < 0 {
+		return fmt.Errorf("unsupported value model %s. Options=[%s,%s]", mc.ValueModel, strings.Join(valueModels, ","), ValueMixed)
+	}
+	_, err := InitSeriesTracker(mc.SeriesCounter, mc.HLLPrecision)
+	if err != nil {
+		return err
+	}
+	mc.slots = make([]seriesSlot, 0, mc.NumMetrics)
+	for i := 0; i < mc.NumMetrics; i++ {
+		if mc.modelOf(i) != ValueHistogram {
+			mc.slots = append(mc.slots, seriesSlot{metricIdx: i, bucket: -1})
+			continue
+		}
+		for b := range histogramBuckets {
+			mc.slots = append(mc.slots, seriesSlot{metricIdx: i, bucket: b})
+		}
+	}
+	err = mc.validateChurn()
+	if err != nil {
+		return err
+	}
+	if len(mc.Tags) == 0 {
+		if mc.CycleSeries || mc.Backfill > 0 {
+			return fmt.Errorf("cycling through series and backfilling require a tag schema")
+		}
+		return nil
+	}
+	if mc.Backfill > 0 {
+		if mc.Interval < time.Second || mc.Interval%time.Second != 0 {
+			return fmt.Errorf("backfill interval must be a whole number of seconds, got %+v", mc.Interval)
+		}
+		interval := int64(mc.Interval.Seconds())
+		mc.backfillEnd = time.Now().Unix()
+		mc.backfillStart = floorDiv(mc.backfillEnd-int64(mc.Backfill.Seconds()), interval) * interval
+	}
+	total := uint64(len(mc.slots))
+	for _, tag := range mc.Tags {
+		if total > math.MaxUint64/tag.Cardinality {
+			return fmt.Errorf("number of series overflows with tag %s=%d", tag.Key, tag.Cardinality)
+		}
+		total *= tag.Cardinality
+	}
+	return nil
+}
+
+// NumSeries returns the exact number of different series at any point in time. Returns false if random tags are used
+func (mc *MetricsConfig) NumSeries() (uint64, bool) {
+	if len(mc.Tags) == 0 {
+		return 0, false
+	}
+	total := uint64(len(mc.slots))
+	for _, tag := range mc.Tags {
+		total *= tag.Cardinality
+	}
+	return total, true
+}
+
+// NumBackfillPoints returns the number of points sent for all series when backfilling
+func (mc *MetricsConfig) NumBackfillPoints() uint64 {
+	numSeries, ok := mc.NumSeries()
+	if !ok || mc.Backfill <= 0 {
+		return 0
+	}
+	numTimestamps := uint64((mc.backfillEnd-mc.backfillStart)/int64(mc.Interval.Seconds())) + 1
+	return numSeries * numTimestamps
+}
+
+func (mc *MetricsConfig) modelIdx(model string) int {
+	for i, m := range valueModels {
+		if m == model {
+			return i
+		}
+	}
+	return -1
+}
+
+// series returns the slot and tags of series id at ts, where 0 <= id < NumSeries.
+// The slot changes fastest, followed by the tags in the order of the schema
+func (mc *MetricsConfig) series(id uint64, ts int64) (seriesSlot, map[string]interface{}) {
+	slot := mc.slots[id%uint64(len(mc.slots))]
+	rest := id / uint64(len(mc.slots))
+	tags := make(map[string]interface{}, len(mc.Tags)+1)
+	for _, tag := range mc.Tags {
+		tags[tag.Key] = fmt.Sprintf("%s-%d", tag.Key, rest%tag.Cardinality)
+		rest /= tag.Cardinality
+	}
+	mc.churn(tags, ts)
+	return slot, tags
+}
+
+// SeriesOf returns the tags of every series of metric, including the le tag of histogram buckets.
+// Not supported with churn, since the tags of churned series change over time
+func (mc *MetricsConfig) SeriesOf(metric string) ([]map[string]interface{}, error) {
+	numSeries, ok := mc.NumSeries()
+	if !ok {
+		return nil, fmt.Errorf("listing the series of a metric requires a tag schema")
+	}
+	if mc.ChurnInterval > 0 {
+		return nil, fmt.Errorf("listing the series of a metric is not supported with churn")
+	}
+	series := make([]map[string]interface{}, 0)
+	for id := uint64(0); id < numSeries; id++ {
+		slot, tags := mc.series(id, 0)
+		if slot.name() != metric {
+			continue
+		}
+		if slot.bucket >= 0 {
+			tags[histogramTag] = formatLe(histogramBuckets[slot.bucket])
+		}
+		series = append(series, tags)
+	}
+	return series, nil
+}
+
+func (s seriesSlot) name() string {
+	if s.bucket >= 0 {
+		return fmt.Sprintf("%s%d%s", metricPrefix, s.metricIdx, histogramSuffix)
+	}
+	return fmt.Sprintf("%s%d", metricPrefix, s.metricIdx)
+}
+
+// MetricOfModel returns the name of the first metric that uses model. Returns false if no metric uses it
+func (mc *MetricsConfig) MetricOfModel(model string) (string, bool) {
+	for _, slot := range mc.slots {
+		if mc.modelOf(slot.metricIdx) == model {
+			return slot.name(), true
+		}
+	}
+	return "", false
+}
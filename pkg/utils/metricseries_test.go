@@ -0,0 +1,186 @@
+package utils
+
+import (
+	"hash/fnv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_cycleSeriesSendsEverySeries(t *testing.T) {
+	tags, err := ParseTagSchema("host=10,pod=5,region=3")
+	assert.NoError(t, err)
+	cfg := &MetricsConfig{NumMetrics: 4, Tags: tags, CycleSeries: true}
+	assert.NoError(t, cfg.Init())
+	numSeries, ok := cfg.NumSeries()
+	assert.True(t, ok)
+	assert.Equal(t, uint64(600), numSeries)
+
+	// 600 is a multiple of 3, shares a factor with 4 and is coprime to 7
+	for _, numWorkers := range []int{3, 4, 7} {
+		seen := make(map[string]int)
+		for w := 0; w < numWorkers; w++ {
+			mg := InitMetricsGenerator(cfg, DeriveSeed(1, w), w, numWorkers)
+			assert.NoError(t, mg.Init())
+			// a cycle of worker w sends the series below numSeries that are w modulo numWorkers
+			cycle := (int(numSeries) - w + numWorkers - 1) / numWorkers
+			for i := 0; i < 2*cycle; i++ {
+				m, err := mg.GetRawLog()
+				assert.NoError(t, err)
+				seen[seriesKey(m["metric"].(string), m["tags"].(map[string]interface{}))]++
+			}
+		}
+		assert.Len(t, seen, int(numSeries), "%d workers", numWorkers)
+		for _, count := range seen {
+			assert.Equal(t, 2, count, "%d workers", numWorkers)
+		}
+	}
+}
+
+func Test_ParseTagSchema(t *testing.T) {
+	_, err := ParseTagSchema("host=10,host=5")
+	assert.Error(t, err)
+	_, err = ParseTagSchema("host=0")
+	assert.Error(t, err)
+	_, err = ParseTagSchema("host")
+	assert.Error(t, err)
+	assert.Error(t, (&MetricsConfig{NumMetrics: 1, CycleSeries: true}).Init())
+}
+
+func Test_valuesCanBeRecomputed(t *testing.T) {
+	tags, err := ParseTagSchema("host=3,region=2")
+	assert.NoError(t, err)
+	cfg := &MetricsConfig{NumMetrics: 5, Tags: tags, CycleSeries: true}
+	assert.NoError(t, cfg.Init())
+	numSeries, _ := cfg.NumSeries()
+	assert.Equal(t, uint64((4+len(histogramBuckets))*3*2), numSeries)
+
+	mg := InitMetricsGenerator(cfg, 1, 0, 1)
+	assert.NoError(t, mg.Init())
+	for i := 0; i < int(numSeries); i++ {
+		m, err := mg.GetRawLog()
+		assert.NoError(t, err)
+		val, err := cfg.Value(m["metric"].(string), m["tags"].(map[string]interface{}), m["timestamp"].(int64))
+		assert.NoError(t, err)
+		assert.Equal(t, m["value"], val)
+	}
+}
+
+func Test_valueModelShapes(t *testing.T) {
+	h := seriesHash("testmetric0", map[string]interface{}{"host": "host-0"})
+	ts := int64(1_700_000_000)
+	resets := 0
+	for i := int64(1); i < 2*secondsPerDay; i += 60 {
+		if seriesValue(ValueCounter, h, 0, ts+i) < seriesValue(ValueCounter, h, 0, ts+i-60) {
+			resets++
+		}
+		prev := -1.0
+		for _, le := range histogramBuckets {
+			bucket := seriesValue(ValueHistogram, h, le, ts+i)
+			assert.GreaterOrEqual(t, bucket, prev)
+			prev = bucket
+		}
+		assert.Equal(t, seriesValue(ValueCounter, h, 0, ts+i), prev)
+	}
+	assert.Greater(t, resets, 0)
+	assert.Less(t, resets, 50)
+}
+
+func Test_churnReplacesFractionOfSeries(t *testing.T) {
+	for _, lifetime := range []string{ChurnExponential, ChurnFixed} {
+		tags, err := ParseTagSchema("host=10,pod=100")
+		assert.NoError(t, err)
+		cfg := &MetricsConfig{NumMetrics: 1, Tags: tags, ValueModel: ValueGauge,
+			ChurnInterval: 10 * time.Minute, ChurnFraction: 0.2, ChurnLifetime: lifetime}
+		assert.NoError(t, cfg.Init())
+		numSeries, _ := cfg.NumSeries()
+
+		ts := int64(1_700_000_400)
+		before := make(map[string]bool)
+		after := make(map[string]bool)
+		for id := uint64(0); id < numSeries; id++ {
+			_, tags := cfg.series(id, ts)
+			before[tags["pod"].(string)+tags["host"].(string)] = true
+			_, tags = cfg.series(id, ts+600)
+			after[tags["pod"].(string)+tags["host"].(string)] = true
+		}
+		assert.Len(t, before, int(numSeries))
+		assert.Len(t, after, int(numSeries))
+		replaced := 0
+		for k := range after {
+			if !before[k] {
+				replaced++
+			}
+		}
+		assert.InDelta(t, 0.2*float64(numSeries), replaced, 0.05*float64(numSeries), lifetime)
+	}
+}
+
+func Test_exponentialLifetimeStart(t *testing.T) {
+	for _, fraction := range []float64{0.5, 0.05, 0.0001} {
+		cfg := &MetricsConfig{ChurnFraction: fraction, ChurnLifetime: ChurnExponential}
+		replaced, windows := 0, 0
+		for h := uint64(0); h < 200; h++ {
+			prev := cfg.lifetimeStart(h, 1_000_000)
+			assert.LessOrEqual(t, prev, int64(1_000_000))
+			for w := int64(1_000_001); w < 1_002_000; w++ {
+				start := cfg.lifetimeStart(h, w)
+				// a lifetime either goes on or a new one starts
+				if start != prev {
+					assert.Equal(t, w, start, fraction)
+					replaced++
+				}
+				prev = start
+				windows++
+			}
+		}
+		assert.InDelta(t, fraction, float64(replaced)/float64(windows), 0.1*fraction+0.0002, fraction)
+	}
+}
+
+func Test_hashString(t *testing.T) {
+	for _, s := range []string{"", "host-0", "testmetric0{host=host-1,pod=pod-7}", "東京"} {
+		h := fnv.New64a()
+		h.Write([]byte(s))
+		assert.Equal(t, h.Sum64(), hashString(s), s)
+		assert.Equal(t, hashString(s), hashValue(s), s)
+	}
+	assert.Equal(t, hashString("7"), hashValue(7))
+}
+
+func Test_seriesCounts(t *testing.T) {
+	for _, counter := range []string{CounterExact, CounterHLL} {
+		tags, err := ParseTagSchema("host=100,region=4")
+		assert.NoError(t, err)
+		cfg := &MetricsConfig{NumMetrics: 50, Tags: tags, ValueModel: ValueGauge, CycleSeries: true,
+			SeriesCounter: counter, HLLPrecision: 14}
+		assert.NoError(t, cfg.Init())
+		numSeries, _ := cfg.NumSeries()
+
+		numWorkers := 4
+		trackers := make([]*SeriesTracker, 0)
+		for w := 0; w < numWorkers; w++ {
+			mg := InitMetricsGenerator(cfg, DeriveSeed(1, w), w, numWorkers)
+			assert.NoError(t, mg.Init())
+			for i := 0; i < int(numSeries); i++ {
+				_, err := mg.GetRawLog()
+				assert.NoError(t, err)
+			}
+			trackers = append(trackers, mg.SeriesTracker())
+		}
+		counts := MergeSeriesCounts(trackers)
+		assert.InDelta(t, numSeries, counts.Total, 0.02*float64(numSeries), counter)
+		assert.InDelta(t, numSeries, counts.Active, 0.02*float64(numSeries), counter)
+		assert.InDelta(t, 400, counts.PerMetric["testmetric7"], 8, counter)
+		assert.InDelta(t, 100, counts.PerTagKey["host"], 2, counter)
+		assert.Len(t, counts.PerMetric, 50)
+
+		counts = MergeSeriesCounts(trackers)
+		assert.Equal(t, uint64(0), counts.Active)
+		if counter == CounterExact {
+			assert.Equal(t, numSeries, counts.Total)
+			assert.Equal(t, uint64(4), counts.PerTagKey["region"])
+		}
+	}
+}
@@ -2,80 +2,163 @@ package utils
 
 import (
 	"fmt"
+	"io"
+	"sort"
 	"strings"
 	"time"
 
 	"github.com/brianvoe/gofakeit/v6"
-	"github.com/liangyaopei/hyper"
-	"github.com/valyala/fastrand"
 )
 
-var metricsHLL = hyper.New(10, true)
-
 type MetricsGenerator struct {
-	nMetrics uint32
-	f        *gofakeit.Faker
-	val      float64
+	cfg       *MetricsConfig
+	numSeries uint64
+	f         *gofakeit.Faker
+	tracker   *SeriesTracker
+
+	// next series id to send and the number of ids to skip after it when cycling
+	nextSeries uint64
+	step       uint64
+
+	// first series id of this worker. backfill only, the timestamp being sent
+	firstSeries uint64
+	backfillTs  int64
 }
 
-func InitMetricsGenerator(nmetrics int) *MetricsGenerator {
-	return &MetricsGenerator{
-		nMetrics: uint32(nmetrics),
-		f:        gofakeit.NewUnlocked(int64(fastrand.Uint32n(1_000))),
-		val:      0,
+// when cycling through series, process workerIdx sends series workerIdx, workerIdx+numWorkers, ... below the number
+// of series and starts over, so the processes partition the series and each sends its own series once per cycle.
+// If there are more processes than series, processes workerIdx >= numSeries repeat series workerIdx % numSeries.
+// When backfilling, each process sends the same series for every timestamp, and processes without series send nothing
+func InitMetricsGenerator(cfg *MetricsConfig, seed int64, workerIdx, numWorkers int) *MetricsGenerator {
+	mg := &MetricsGenerator{
+		cfg: cfg,
+		f:   gofakeit.NewUnlocked(seed),
+	}
+	numSeries, ok := cfg.NumSeries()
+	if !ok {
+		return mg
+	}
+	mg.numSeries = numSeries
+	if cfg.Backfill > 0 {
+		mg.firstSeries = uint64(workerIdx)
+		mg.nextSeries = mg.firstSeries
+		mg.step = uint64(numWorkers)
+		mg.backfillTs = cfg.backfillStart
+	} else {
+		mg.firstSeries = uint64(workerIdx) % numSeries
+		mg.nextSeries = mg.firstSeries
+		mg.step = uint64(numWorkers)
 	}
+	return mg
 }
 
 func (mg *MetricsGenerator) Init(fName ...string) error {
+	tracker, err := InitSeriesTracker(mg.cfg.SeriesCounter, mg.cfg.HLLPrecision)
+	if err != nil {
+		return err
+	}
+	mg.tracker = tracker
 	return nil
 }
 
+// SeriesTracker returns the unique series sent by this generator
+func (mg *MetricsGenerator) SeriesTracker() *SeriesTracker {
+	return mg.tracker
+}
+
 func (mg *MetricsGenerator) GetLogLine() ([]byte, error) {
 	return nil, fmt.Errorf("metrics generator can only be used with GetRawLog")
 }
 
+// When backfilling, returns io.EOF once every series of this process has been sent for the whole range
 func (mg *MetricsGenerator) GetRawLog() (map[string]interface{}, error) {
 
-	retVal := make(map[string]interface{})
-	mName := fmt.Sprintf("testmetric%d", fastrand.Uint32n(mg.nMetrics))
-	retVal["metric"] = mName
-	retVal["timestamp"] = time.Now().Unix()
-	if fastrand.Uint32n(1_000)%2 == 0 {
-		mg.val = float64(fastrand.Uint32n(1_000))
+	var slot seriesSlot
+	var tags map[string]interface{}
+	ts := time.Now().Unix()
+	if mg.cfg.Backfill > 0 {
+		id, backfillTs, err := mg.nextBackfillPoint()
+		if err != nil {
+			return nil, err
+		}
+		ts = backfillTs
+		slot, tags = mg.cfg.series(id, ts)
+	} else if mg.numSeries > 0 {
+		slot, tags = mg.cfg.series(mg.nextSeriesId(), ts)
+	} else {
+		slot = mg.cfg.slots[mg.f.Rand.Intn(len(mg.cfg.slots))]
+		tags = mg.randomTags()
 	}
-	retVal["value"] = mg.val
 
-	var str strings.Builder
-	str.WriteString(mName)
+	baseName := fmt.Sprintf("%s%d", metricPrefix, slot.metricIdx)
+	h := seriesHash(baseName, tags)
+	mName := baseName
+	le := 0.0
+	if slot.bucket >= 0 {
+		mName += histogramSuffix
+		le = histogramBuckets[slot.bucket]
+		tags[histogramTag] = formatLe(le)
+	}
 
-	tags := make(map[string]interface{})
+	retVal := make(map[string]interface{})
+	retVal["metric"] = mName
+	retVal["timestamp"] = ts
+	retVal["value"] = seriesValue(mg.cfg.modelOf(slot.metricIdx), h, le, ts)
+	retVal["tags"] = tags
 
-	sColor := mg.f.SafeColor()
-	tags["color"] = sColor
-	str.WriteString(sColor)
+	mg.tracker.add(mName, tags)
 
-	group := fmt.Sprintf("group %d", fastrand.Uint32n(2))
-	tags["group"] = group
-	str.WriteString(group)
+	return retVal, nil
+}
 
+func (mg *MetricsGenerator) randomTags() map[string]interface{} {
+	tags := make(map[string]interface{})
+	tags["color"] = mg.f.SafeColor()
+	tags["group"] = fmt.Sprintf("group %d", mg.f.Rand.Intn(2))
 	c := mg.f.Car()
 	tags["car_type"] = c.Type
-	str.WriteString(c.Type)
-
 	tags["fuel_type"] = c.Fuel
-	str.WriteString(c.Fuel)
-
 	tags["model"] = c.Model
-	str.WriteString(c.Model)
-
-	retVal["tags"] = tags
+	return tags
+}
 
-	finalStr := str.String()
-	metricsHLL.AddString(finalStr)
+func (mg *MetricsGenerator) nextSeriesId() uint64 {
+	if !mg.cfg.CycleSeries {
+		return mg.f.Rand.Uint64() % mg.numSeries
+	}
+	id := mg.nextSeries
+	mg.nextSeries += mg.step
+	if mg.nextSeries >= mg.numSeries {
+		mg.nextSeries = mg.firstSeries
+	}
+	return id
+}
 
-	return retVal, nil
+// sends all series of this process for a timestamp before moving to the next timestamp
+func (mg *MetricsGenerator) nextBackfillPoint() (uint64, int64, error) {
+	if mg.nextSeries >= mg.numSeries {
+		mg.nextSeries = mg.firstSeries
+		mg.backfillTs += int64(mg.cfg.Interval.Seconds())
+	}
+	if mg.firstSeries >= mg.numSeries || mg.backfillTs > mg.cfg.backfillEnd {
+		return 0, 0, io.EOF
+	}
+	id := mg.nextSeries
+	mg.nextSeries += mg.step
+	return id, mg.backfillTs, nil
 }
 
-func GetMetricsHLL() uint64 {
-	return metricsHLL.Count()
+// unique key of a series. Tags are sorted by key
+func seriesKey(mName string, tags map[string]interface{}) string {
+	keys := make([]string, 0, len(tags))
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var str strings.Builder
+	str.WriteString(mName)
+	for _, k := range keys {
+		str.WriteString(fmt.Sprintf(",%s=%v", k, tags[k]))
+	}
+	return str.String()
 }
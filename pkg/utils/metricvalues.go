@@ -0,0 +1,144 @@
+package utils
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Models for the values of generated series. The value of a series only depends on its metric, tags and timestamp
+const (
+	// monotonic count with a per series rate that resets to 0 every few hours
+	ValueCounter = "counter"
+	// bounded random walk made of smoothed noise at several time scales
+	ValueGauge = "gauge"
+	// daily sine wave with a per series base, amplitude and phase
+	ValueSine = "sine"
+	// constant level that jumps to a new value every few minutes
+	ValueStep = "step"
+	// cumulative buckets of a histogram. Metric names end with _bucket and each series has an extra le tag
+	ValueHistogram = "histogram"
+	// each metric uses one of the models above, in order of the metric number
+	ValueMixed = "mixed"
+)
+
+var valueModels = []string{ValueCounter, ValueGauge, ValueSine, ValueStep, ValueHistogram}
+
+var histogramBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, math.Inf(1)}
+
+// HistogramBuckets returns the le values of the buckets of histogram metrics, ending with +Inf
+func HistogramBuckets() []float64 {
+	return append([]float64(nil), histogramBuckets...)
+}
+
+const (
+	metricPrefix    = "testmetric"
+	histogramSuffix = "_bucket"
+	histogramTag    = "le"
+	secondsPerDay   = 24 * 60 * 60
+)
+
+func (mc *MetricsConfig) modelOf(metricIdx int) string {
+	if mc.ValueModel == ValueMixed {
+		return valueModels[metricIdx%len(valueModels)]
+	}
+	return mc.ValueModel
+}
+
+// Value computes the value of a generated series at ts, in epoch seconds. It can be used to check query results
+func (mc *MetricsConfig) Value(metric string, tags map[string]interface{}, ts int64) (float64, error) {
+	baseName := strings.TrimSuffix(metric, histogramSuffix)
+	metricIdx, err := strconv.Atoi(strings.TrimPrefix(baseName, metricPrefix))
+	if err != nil || !strings.HasPrefix(baseName, metricPrefix) {
+		return 0, fmt.Errorf("%s is not a generated metric", metric)
+	}
+	model := mc.modelOf(metricIdx)
+	le := 0.0
+	if model == ValueHistogram {
+		rawLe, ok := tags[histogramTag]
+		if !ok || baseName == metric {
+			return 0, fmt.Errorf("histogram metric %s needs the %s suffix and an %s tag", metric, histogramSuffix, histogramTag)
+		}
+		le, err = strconv.ParseFloat(fmt.Sprintf("%v", rawLe), 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid %s tag %v: %w", histogramTag, rawLe, err)
+		}
+		tags = copyMap(tags)
+		delete(tags, histogramTag)
+	}
+	return seriesValue(model, seriesHash(baseName, tags), le, ts), nil
+}
+
+// identifies a series for its value model. Buckets of the same histogram have the same hash
+func seriesHash(baseName string, tags map[string]interface{}) uint64 {
+	return hashString(seriesKey(baseName, tags))
+}
+
+// le is only used by histograms
+func seriesValue(model string, h uint64, le float64, ts int64) float64 {
+	switch model {
+	case ValueCounter:
+		return counterValue(h, ts)
+	case ValueGauge:
+		scale := 10 + hashUnit(h, 1)*990
+		walk := 0.0
+		for i := int64(0); i < 5; i++ {
+			period := 60 * int64(math.Pow(4, float64(i)))
+			walk += math.Pow(0.5, float64(i)) * (2*smoothNoise(uint64(DeriveSeed(int64(h), int(100+i))), ts, period) - 1)
+		}
+		return math.Round(scale*(2+walk)*100) / 100
+	case ValueSine:
+		base := 100 + hashUnit(h, 1)*900
+		amplitude := base * (0.2 + 0.6*hashUnit(h, 2))
+		phase := hashUnit(h, 3) * secondsPerDay
+		return math.Round((base+amplitude*math.Sin(2*math.Pi*(float64(ts)+phase)/secondsPerDay))*100) / 100
+	case ValueStep:
+		period := int64(600 * (1 + int(hashUnit(h, 1)*12)))
+		return math.Floor(hashUnit(uint64(DeriveSeed(int64(h), 4)), floorDiv(ts, period)) * 1_000)
+	case ValueHistogram:
+		// observations are exponentially distributed with a per series mean
+		mean := 0.05 + hashUnit(h, 4)*0.95
+		return math.Floor(counterValue(h, ts) * (1 - math.Exp(-le/mean)))
+	default:
+		return 0
+	}
+}
+
+func counterValue(h uint64, ts int64) float64 {
+	rate := 1 + hashUnit(h, 1)*99
+	resetPeriod := int64(3600 * (1 + int(hashUnit(h, 2)*24)))
+	offset := int64(hashUnit(h, 3) * float64(resetPeriod))
+	sinceReset := ts + offset - floorDiv(ts+offset, resetPeriod)*resetPeriod
+	return math.Floor(rate * float64(sinceReset))
+}
+
+// value in [0, 1) that changes smoothly between random points every period seconds
+func smoothNoise(h uint64, ts int64, period int64) float64 {
+	window := floorDiv(ts, period)
+	frac := float64(ts-window*period) / float64(period)
+	a := hashUnit(h, window)
+	b := hashUnit(h, window+1)
+	s := frac * frac * (3 - 2*frac)
+	return a + (b-a)*s
+}
+
+// uniform value in [0, 1) for series h and index i
+func hashUnit(h uint64, i int64) float64 {
+	return float64(uint64(DeriveSeed(int64(h), int(i)))>>11) / (1 << 53)
+}
+
+func floorDiv(a, b int64) int64 {
+	q := a / b
+	if a%b != 0 && a < 0 {
+		q--
+	}
+	return q
+}
+
+func formatLe(le float64) string {
+	if math.IsInf(le, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(le, 'g', -1, 64)
+}
@@ -1,11 +1,8 @@
 package utils
 
 import (
-	"bufio"
-	"errors"
 	"fmt"
-	"math/rand"
-	"os"
+	"io"
 	"regexp"
 	"strings"
 	"sync"
@@ -15,10 +12,15 @@ import (
 	"github.com/brianvoe/gofakeit/v6"
 	jsoniter "github.com/json-iterator/go"
 	log "github.com/sirupsen/logrus"
-	"github.com/valyala/fastrand"
 )
 
-var json = jsoniter.ConfigFastest
+// same as jsoniter.ConfigFastest, but with sorted map keys so that seeded generators produce identical bytes
+var json = jsoniter.Config{
+	EscapeHTML:                    false,
+	MarshalFloatWith6Digits:       true,
+	ObjectFieldMustBeSimpleString: true,
+	SortMapKeys:                   true,
+}.Froze()
 
 type Generator interface {
 	Init(fName ...string) error
@@ -26,12 +28,25 @@ type Generator interface {
 	GetRawLog() (map[string]interface{}, error)
 }
 
-// file reader loads chunks from the file. Each request will get a sequential entry from the chunk.
+// file reader loads chunks from the files. Each request will get a sequential entry from the chunk.
 // When the read index is close to the next chunk, pre-load next chunks
-// Chunks should loop over the file multiple times if necessary
+// Chunks should loop over the files multiple times if necessary
+// The input can be a single file, a glob or a directory. gzip and zstd files are decompressed transparently.
+// Files can be newline delimited json, csv, tsv, logfmt or parquet. Every record is sent as a json document.
+// When there are multiple workers, files are split among them. If there are fewer files than workers,
+// every worker reads all files but only keeps every numWorkers-th line.
 type FileReader struct {
-	file    string
-	lineNum int //number of lines read. lets us know where to start from
+	format      string
+	files       []string
+	fileIdx     int // index of the file currently being read
+	lineNum     int // number of lines read in the current pass over all files
+	workerIdx   int
+	numWorkers  int
+	shardByLine bool
+	sawLine     bool // has this worker read at least one line from its files?
+	tsRewriter  *TimestampRewriter
+
+	currFile recordReader
 
 	editLock *sync.Mutex
 
@@ -47,13 +62,17 @@ type FileReader struct {
 type StaticGenerator struct {
 	logLine []byte
 	ts      bool
+	seed    int64
 }
 type K8sGenerator struct {
-	baseBody  map[string]interface{}
-	tNowEpoch uint64
-	ts        bool
-	faker     *gofakeit.Faker
-	seed      int64
+	baseBody     map[string]interface{}
+	tNowEpoch    uint64
+	ts           bool
+	faker        *gofakeit.Faker
+	seed         int64
+	topologySeed int64
+	topology     *k8sTopology
+	numEvents    int64
 }
 
 type DynamicUserGenerator struct {
@@ -71,22 +90,50 @@ func InitDynamicUserGenerator(ts bool, seed int64) *DynamicUserGenerator {
 	}
 }
 
-func InitK8sGenerator(ts bool, seed int64) *K8sGenerator {
+// seed is used for the events and topologySeed for the clusters, nodes and pods.
+// Generators that should send logs from the same topology need the same topologySeed
+func InitK8sGenerator(ts bool, seed int64, topologySeed int64) *K8sGenerator {
 
 	return &K8sGenerator{
-		ts:   ts,
-		seed: seed,
+		ts:           ts,
+		seed:         seed,
+		topologySeed: topologySeed,
 	}
 }
 
-func InitStaticGenerator(ts bool) *StaticGenerator {
+func InitStaticGenerator(ts bool, seed int64) *StaticGenerator {
 	return &StaticGenerator{
-		ts: ts,
+		ts:   ts,
+		seed: seed,
 	}
 }
 
-func InitFileReader() *FileReader {
-	return &FileReader{}
+// DefaultSeed is the seed of the generators when none is given
+const DefaultSeed = 1001
+
+// DeriveSeed returns a seed for the worker workerIdx. Every worker gets a different seed, and the same
+// base seed and worker always get the same one
+func DeriveSeed(base int64, workerIdx int) int64 {
+	// splitmix64
+	z := uint64(base) + uint64(workerIdx+1)*0x9e3779b97f4a7c15
+	z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+	z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+	return int64(z ^ (z >> 31))
+}
+
+// format is one of FormatJSON, FormatCSV, FormatTSV, FormatLogfmt or FormatParquet.
+// tsRewriter is optional and rewrites a timestamp field of every record.
+// workerIdx is 0 based. The files passed to Init will be split among numWorkers readers
+func InitFileReader(format string, tsRewriter *TimestampRewriter, workerIdx, numWorkers int) *FileReader {
+	if numWorkers < 1 {
+		numWorkers = 1
+	}
+	return &FileReader{
+		format:     format,
+		tsRewriter: tsRewriter,
+		workerIdx:  workerIdx,
+		numWorkers: numWorkers,
+	}
 }
 
 var logMessages = []string{
@@ -97,7 +144,7 @@ var logMessages = []string{
 	"Could not construct reference to: '%v' due to: '%v'. Will not report event: '%v' '%v' '%v'",
 }
 
-// replacePlaceholders replaces formatting placeholders in a string with random values.
+// replacePlaceholders replaces formatting placeholders in a string with random values from f.
 // The placeholders are identified using the % character followed by a type specifier:
 //   - %s: Replaced with a random word.
 //   - %q: Replaced with a random buzzword.
@@ -105,7 +152,7 @@ var logMessages = []string{
 //
 // Placeholders within single or double quotes are also supported.
 // For example, "Error: %v is not %s" could become "Error: 42 is not foo".
-func replacePlaceholders(template string) string {
+func replacePlaceholders(f *gofakeit.Faker, template string) string {
 
 	// The regex (placeholderRegex) captures placeholders in a string.
 	// It matches placeholders within single/double quotes or unquoted,
@@ -121,13 +168,13 @@ func replacePlaceholders(template string) string {
 		var replacement string
 		switch placeholderType {
 		case "s":
-			replacement = gofakeit.Word()
+			replacement = f.Word()
 
 		case "q":
-			replacement = gofakeit.BuzzWord()
+			replacement = f.BuzzWord()
 
 		case "v":
-			replacement = fmt.Sprintf("%d", gofakeit.Number(1, 100))
+			replacement = fmt.Sprintf("%d", f.Number(1, 100))
 
 		default:
 			replacement = "UNKNOWN"
@@ -190,26 +237,44 @@ func (r *DynamicUserGenerator) generateRandomBody() {
 }
 
 func (r *K8sGenerator) createK8sBody() {
-	randomTemplate := logMessages[gofakeit.Number(0, len(logMessages)-1)]
-	logEntry := replacePlaceholders(randomTemplate)
+	randomTemplate := logMessages[r.faker.Number(0, len(logMessages)-1)]
+	logEntry := replacePlaceholders(r.faker, randomTemplate)
+	// without timestamps, pods churn every k8sEventsPerChurnWindow events so the generated data does not depend
+	// on the wall clock
+	now := time.Now()
+	window := r.numEvents / k8sEventsPerChurnWindow
+	if r.ts {
+		window = floorDiv(now.UnixNano(), int64(k8sChurnWindow))
+	}
+	r.numEvents++
+	pod := r.topology.nextPod(r.faker, window)
+	pod.addMetadata(r.baseBody, r.faker.Number(0, len(pod.deploy.containers)-1))
+	httpStatus := r.faker.HTTPStatusCodeSimple()
 	r.baseBody["batch"] = fmt.Sprintf("batch-%d", r.faker.Number(1, 1000))
-	r.baseBody["DomainName"] = r.faker.DomainName()
-	r.baseBody["Region"] = r.faker.TimeZoneRegion()
-	r.baseBody["Az"] = r.faker.Country()
-	r.baseBody["hostname"] = r.faker.IPv4Address()
-	r.baseBody["httpStatus"] = r.faker.HTTPStatusCodeSimple()
+	r.baseBody["httpStatus"] = httpStatus
 	r.baseBody["UserAgent"] = r.faker.UserAgent()
 	r.baseBody["Url"] = r.faker.URL()
 	r.baseBody["latency"] = r.faker.Number(0, 100)
-	r.baseBody["IPv4Address"] = r.faker.IPv4Address()
-	r.baseBody["Port"] = r.faker.Number(0, 65535)
 	r.baseBody["msg"] = logEntry
+	switch {
+	case httpStatus >= 500:
+		r.baseBody["level"] = "error"
+		r.baseBody["stream"] = "stderr"
+	case httpStatus >= 400:
+		r.baseBody["level"] = "warn"
+		r.baseBody["stream"] = "stderr"
+	default:
+		r.baseBody["level"] = "info"
+		r.baseBody["stream"] = "stdout"
+	}
+	if r.ts {
+		r.baseBody["timestamp"] = uint64(now.UnixMilli())
+	}
 }
 
 func (r *K8sGenerator) Init(fName ...string) error {
-	gofakeit.Seed(r.seed)
 	r.faker = gofakeit.NewUnlocked(r.seed)
-	rand.Seed(r.seed)
+	r.topology = newK8sTopology(r.topologySeed)
 	r.baseBody = make(map[string]interface{})
 	r.createK8sBody()
 	body, err := json.Marshal(r.baseBody)
@@ -223,9 +288,7 @@ func (r *K8sGenerator) Init(fName ...string) error {
 }
 
 func (r *DynamicUserGenerator) Init(fName ...string) error {
-	gofakeit.Seed(r.seed)
 	r.faker = gofakeit.NewUnlocked(r.seed)
-	rand.Seed(r.seed)
 	r.baseBody = make(map[string]interface{})
 	r.generateRandomBody()
 	body, err := json.Marshal(r.baseBody)
@@ -260,7 +323,7 @@ func (r *K8sGenerator) GetRawLog() (map[string]interface{}, error) {
 
 func (r *StaticGenerator) Init(fName ...string) error {
 	m := make(map[string]interface{})
-	f := gofakeit.NewUnlocked(int64(fastrand.Uint32n(1_000)))
+	f := gofakeit.NewUnlocked(r.seed)
 	randomizeBody(f, m, r.ts)
 	body, err := json.Marshal(m)
 	if err != nil {
@@ -288,17 +351,27 @@ func (sr *StaticGenerator) GetRawLog() (map[string]interface{}, error) {
 var chunkSize int = 10000
 
 func (fr *FileReader) Init(fName ...string) error {
-	fr.file = fName[0]
+	files, err := resolveInputFiles(fName[0])
+	if err != nil {
+		return err
+	}
+	if fr.tsRewriter != nil {
+		err = fr.tsRewriter.prepare(files, fr.format)
+		if err != nil {
+			return err
+		}
+	}
+	fr.files, fr.shardByLine = assignFilesToWorker(files, fr.workerIdx, fr.numWorkers)
+	log.Infof("File reader %d/%d will read %d files. Sharding by line: %+v", fr.workerIdx+1, fr.numWorkers,
+		len(fr.files), fr.shardByLine)
+	fr.fileIdx = 0
 	fr.lineNum = 0
 	fr.logLines = make([][]byte, 0)
 	fr.nextLogLines = make([][]byte, 0)
 	fr.isChunkPrefetched = false
 	fr.asyncPrefetch = false
 	fr.editLock = &sync.Mutex{}
-	if _, err := os.Stat(fName[0]); errors.Is(err, os.ErrNotExist) {
-		return err
-	}
-	err := fr.swapChunks()
+	err = fr.swapChunks()
 	if err != nil {
 		return err
 	}
@@ -323,9 +396,26 @@ func (fr *FileReader) GetLogLine() ([]byte, error) {
 	if fr.currIdx > len(fr.logLines)/2 {
 		go func() { _ = fr.prefetchChunk(false) }()
 	}
+	if fr.tsRewriter != nil && fr.tsRewriter.mode == TsModeNow {
+		return fr.restamp(retVal)
+	}
 	return retVal, nil
 }
 
+// sets the timestamp of the log line to the current time
+func (fr *FileReader) restamp(logLine []byte) ([]byte, error) {
+	m := make(map[string]interface{})
+	err := json.Unmarshal(logLine, &m)
+	if err != nil {
+		return nil, err
+	}
+	err = fr.tsRewriter.rewrite(m)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(m)
+}
+
 func (fr *FileReader) GetRawLog() (map[string]interface{}, error) {
 	rawLog, err := fr.GetLogLine()
 	if err != nil {
@@ -364,51 +454,73 @@ func (fr *FileReader) prefetchChunk(override bool) error {
 	}
 	fr.asyncPrefetch = true
 	defer func() { fr.asyncPrefetch = false }()
-	fd, err := os.Open(fr.file)
-	if err != nil {
-		log.Errorf("Failed to open file %s: %+v", fr.file, err)
-		return err
-	}
-	defer fd.Close()
-	_, err = fd.Seek(0, 0)
-	if err != nil {
-		return err
-	}
-	fileScanner := bufio.NewScanner(fd)
-	tmpMap := make(map[string]interface{})
-	lNum := 0
-	for fileScanner.Scan() {
-		if lNum < fr.lineNum {
-			lNum++
+	for len(fr.nextLogLines) <= chunkSize {
+		if fr.currFile == nil {
+			err := fr.openCurrentFile()
+			if err != nil {
+				return err
+			}
+		}
+		err := fr.currFile.next()
+		if err == io.EOF {
+			fr.closeCurrentFile()
+			fr.fileIdx++
+			if fr.fileIdx < len(fr.files) {
+				continue
+			}
+			// this will only happen if we reached the end of all files before filling the chunk
+			fr.fileIdx = 0
+			fr.lineNum = 0
+			if !fr.sawLine {
+				return fmt.Errorf("no log lines found for file reader %d/%d in %+v", fr.workerIdx+1, fr.numWorkers, fr.files)
+			}
+			return nil
+		} else if err != nil {
+			log.Errorf("error reading file %s: %+v", fr.files[fr.fileIdx], err)
+			return err
+		}
+		lNum := fr.lineNum
+		fr.lineNum++
+		if fr.shardByLine && lNum%fr.numWorkers != fr.workerIdx {
 			continue
 		}
-		err := json.Unmarshal(fileScanner.Bytes(), &tmpMap)
+		tmpMap, err := fr.currFile.record()
 		if err != nil {
-			log.Errorf("Failed to unmarshal log entry %+v: lineNum %+v %+v", tmpMap, fr.lineNum, err)
+			log.Errorf("Failed to decode log entry: file %s lineNum %+v %+v", fr.files[fr.fileIdx], lNum, err)
 			return err
 		}
+		if fr.tsRewriter != nil && fr.tsRewriter.mode == TsModeShift {
+			err = fr.tsRewriter.rewrite(tmpMap)
+			if err != nil {
+				log.Errorf("Failed to rewrite timestamp: file %s lineNum %+v %+v", fr.files[fr.fileIdx], lNum, err)
+				return err
+			}
+		}
 		logs, err := json.Marshal(tmpMap)
 		if err != nil {
 			log.Errorf("Failed to marshal log entry %+v: %+v", tmpMap, err)
 			return err
 		}
 		fr.nextLogLines = append(fr.nextLogLines, logs)
-		if len(fr.nextLogLines) > chunkSize {
-			fr.isChunkPrefetched = true
-			break
-		}
-		lNum++
+		fr.sawLine = true
 	}
+	fr.isChunkPrefetched = true
+	return nil
+}
 
-	if err := fileScanner.Err(); err != nil {
-		log.Errorf("error in file scanner %+v", err)
+func (fr *FileReader) openCurrentFile() error {
+	rr, err := openRecordReader(fr.format, fr.files[fr.fileIdx])
+	if err != nil {
+		log.Errorf("Failed to open file %s: %+v", fr.files[fr.fileIdx], err)
 		return err
 	}
-	fr.lineNum = lNum
-	if len(fr.nextLogLines) <= chunkSize {
-		// this will only happen if we reached the end of the file before filling the chunk
-		fr.lineNum = 0
-		return fr.prefetchChunk(true)
-	}
+	fr.currFile = rr
 	return nil
 }
+
+func (fr *FileReader) closeCurrentFile() {
+	if fr.currFile != nil {
+		_ = fr.currFile.Close()
+	}
+	fr.currFile = nil
+}
@@ -2,8 +2,10 @@ package utils
 
 import (
 	"testing"
+	"time"
 
 	"github.com/brianvoe/gofakeit/v6"
+	"github.com/stretchr/testify/assert"
 	"github.com/valyala/fastrand"
 )
 
@@ -14,3 +16,59 @@ func Benchmark_randomBody(b *testing.B) {
 		randomizeBody(f, m, true)
 	}
 }
+
+func Test_seededGeneratorsAreDeterministic(t *testing.T) {
+	g1 := InitK8sGenerator(false, DeriveSeed(42, 0), 42)
+	g2 := InitK8sGenerator(false, DeriveSeed(42, 0), 42)
+	g3 := InitK8sGenerator(false, DeriveSeed(42, 1), 42)
+	assert.NoError(t, g1.Init())
+	assert.NoError(t, g2.Init())
+	assert.NoError(t, g3.Init())
+	// the events cross a churn window, which only depends on the number of events sent
+	for _, g := range []*K8sGenerator{g1, g2, g3} {
+		g.numEvents = 10*k8sEventsPerChurnWindow - 50
+	}
+	for i := 0; i < 100; i++ {
+		l1, err := g1.GetLogLine()
+		assert.NoError(t, err)
+		l2, err := g2.GetLogLine()
+		assert.NoError(t, err)
+		l3, err := g3.GetLogLine()
+		assert.NoError(t, err)
+		assert.Equal(t, l1, l2)
+		assert.NotEqual(t, l1, l3)
+	}
+}
+
+func Test_k8sPodsOnlyDependOnTopologySeedAndWindow(t *testing.T) {
+	incremental := newK8sTopology(42)
+	// the minute of 2023-11-14T22:13:00Z
+	start := floorDiv(1_700_000_000*int64(time.Second), int64(k8sChurnWindow))
+	replaced, restarted := 0, 0
+	for w := start; w < start+2000; w++ {
+		for i, slot := range incremental.slots {
+			prev := slot.pod
+			prevRestarts := 0
+			if prev != nil {
+				prevRestarts = prev.restartCount
+			}
+			pod := slot.podAt(w)
+			if prev != nil && pod != prev {
+				replaced++
+			} else if prev != nil && pod.restartCount != prevRestarts {
+				restarted++
+			}
+			if w%500 != 0 {
+				continue
+			}
+			// a worker that starts at w computes the same pod
+			fresh := newK8sTopology(42).slots[i].podAt(w)
+			assert.Equal(t, fresh.name, pod.name)
+			assert.Equal(t, fresh.uid, pod.uid)
+			assert.Equal(t, fresh.dockerIds, pod.dockerIds)
+			assert.Equal(t, fresh.restartCount, pod.restartCount)
+		}
+	}
+	assert.Greater(t, replaced, 0)
+	assert.Greater(t, restarted, 0)
+}
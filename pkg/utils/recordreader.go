@@ -0,0 +1,327 @@
+package utils
+
+import (
+	"bufio"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/go-logfmt/logfmt"
+	"github.com/xitongsys/parquet-go-source/local"
+	"github.com/xitongsys/parquet-go/common"
+	"github.com/xitongsys/parquet-go/reader"
+	"github.com/xitongsys/parquet-go/source"
+)
+
+// Supported formats of the files read by FileReader
+const (
+	FormatJSON    = "json"
+	FormatCSV     = "csv"
+	FormatTSV     = "tsv"
+	FormatLogfmt  = "logfmt"
+	FormatParquet = "parquet"
+)
+
+const parquetBatchSize = 1_000
+
+// recordReader iterates over the records of a single input file
+type recordReader interface {
+	// next advances to the next record. Returns io.EOF once the file is exhausted
+	next() error
+	// record decodes the current record into a json object
+	record() (map[string]interface{}, error)
+	Close() error
+}
+
+func openRecordReader(format, fName string) (recordReader, error) {
+	switch format {
+	case "", FormatJSON:
+		return openLineRecordReader(fName, decodeJSONLine)
+	case FormatCSV:
+		return openCSVRecordReader(fName)
+	case FormatTSV:
+		return openTSVRecordReader(fName)
+	case FormatLogfmt:
+		return openLineRecordReader(fName, decodeLogfmtLine)
+	case FormatParquet:
+		return openParquetRecordReader(fName)
+	default:
+		return nil, fmt.Errorf("unsupported file format %s", format)
+	}
+}
+
+// InferValue converts a raw text value to an int64, float64 or bool if possible. Otherwise it is kept as a string
+func InferValue(value string) interface{} {
+	// Try converting to a number.
+	intVal, err := strconv.ParseInt(value, 10, 64)
+	if err == nil {
+		return intVal
+	}
+
+	floatVal, err := strconv.ParseFloat(value, 64)
+	if err == nil {
+		return floatVal
+	}
+
+	// Try converting to a boolean.
+	if value == "false" {
+		return false
+	}
+	if value == "true" {
+		return true
+	}
+
+	// Keep this as a string.
+	return value
+}
+
+// RowToMap sets a value for each column in m. Columns without a matching value are set to nil
+func RowToMap(columns []string, rawValues []string, m map[string]interface{}) {
+	for idx, column := range columns {
+		if idx >= len(rawValues) {
+			m[column] = nil
+		} else {
+			m[column] = InferValue(rawValues[idx])
+		}
+	}
+}
+
+// reads newline delimited records and decodes each line with decode
+type lineRecordReader struct {
+	rc      io.ReadCloser
+	scanner *bufio.Scanner
+	decode  func([]byte) (map[string]interface{}, error)
+}
+
+func openLineRecordReader(fName string, decode func([]byte) (map[string]interface{}, error)) (*lineRecordReader, error) {
+	rc, err := openInputFile(fName)
+	if err != nil {
+		return nil, err
+	}
+	scanner := bufio.NewScanner(rc)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxLineSize)
+	return &lineRecordReader{rc: rc, scanner: scanner, decode: decode}, nil
+}
+
+func (lr *lineRecordReader) next() error {
+	if lr.scanner.Scan() {
+		return nil
+	}
+	if err := lr.scanner.Err(); err != nil {
+		return err
+	}
+	return io.EOF
+}
+
+func (lr *lineRecordReader) record() (map[string]interface{}, error) {
+	return lr.decode(lr.scanner.Bytes())
+}
+
+func (lr *lineRecordReader) Close() error {
+	return lr.rc.Close()
+}
+
+func decodeJSONLine(line []byte) (map[string]interface{}, error) {
+	m := make(map[string]interface{})
+	err := json.Unmarshal(line, &m)
+	if err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// key=value pairs. Keys without a value are set to true
+func decodeLogfmtLine(line []byte) (map[string]interface{}, error) {
+	m := make(map[string]interface{})
+	dec := logfmt.NewDecoder(strings.NewReader(string(line)))
+	for dec.ScanRecord() {
+		for dec.ScanKeyval() {
+			if dec.Value() == nil {
+				m[string(dec.Key())] = true
+			} else {
+				m[string(dec.Key())] = InferValue(string(dec.Value()))
+			}
+		}
+	}
+	if err := dec.Err(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// the first row of the file is the header
+type csvRecordReader struct {
+	rc      io.ReadCloser
+	csvRdr  *csv.Reader
+	columns []string
+	row     []string
+}
+
+func openCSVRecordReader(fName string) (*csvRecordReader, error) {
+	rc, err := openInputFile(fName)
+	if err != nil {
+		return nil, err
+	}
+	csvRdr := csv.NewReader(rc)
+	csvRdr.FieldsPerRecord = -1
+	csvRdr.ReuseRecord = true
+	header, err := csvRdr.Read()
+	if err != nil {
+		rc.Close()
+		return nil, fmt.Errorf("failed to read header of %s: %w", fName, err)
+	}
+	columns := make([]string, len(header))
+	copy(columns, header)
+	return &csvRecordReader{rc: rc, csvRdr: csvRdr, columns: columns}, nil
+}
+
+func (cr *csvRecordReader) next() error {
+	row, err := cr.csvRdr.Read()
+	if err != nil {
+		return err
+	}
+	cr.row = row
+	return nil
+}
+
+func (cr *csvRecordReader) record() (map[string]interface{}, error) {
+	m := make(map[string]interface{}, len(cr.columns))
+	RowToMap(cr.columns, cr.row, m)
+	return m, nil
+}
+
+func (cr *csvRecordReader) Close() error {
+	return cr.rc.Close()
+}
+
+// the first line of the file is the header. Values are split on tabs without any quoting, same as cmd/utils/converter.go
+type tsvRecordReader struct {
+	*lineRecordReader
+	columns []string
+}
+
+func openTSVRecordReader(fName string) (*tsvRecordReader, error) {
+	lr, err := openLineRecordReader(fName, nil)
+	if err != nil {
+		return nil, err
+	}
+	if err := lr.next(); err != nil {
+		lr.Close()
+		return nil, fmt.Errorf("failed to read header of %s: %w", fName, err)
+	}
+	tr := &tsvRecordReader{lineRecordReader: lr, columns: strings.Split(lr.scanner.Text(), "\t")}
+	lr.decode = tr.decodeRow
+	return tr, nil
+}
+
+func (tr *tsvRecordReader) decodeRow(line []byte) (map[string]interface{}, error) {
+	m := make(map[string]interface{}, len(tr.columns))
+	RowToMap(tr.columns, strings.Split(string(line), "\t"), m)
+	return m, nil
+}
+
+type parquetRecordReader struct {
+	pf        source.ParquetFile
+	pr        *reader.ParquetReader
+	remaining int64
+	batch     []interface{}
+	batchIdx  int
+}
+
+func openParquetRecordReader(fName string) (*parquetRecordReader, error) {
+	pf, err := local.NewLocalFileReader(fName)
+	if err != nil {
+		return nil, err
+	}
+	pr, err := reader.NewParquetReader(pf, nil, 1)
+	if err != nil {
+		pf.Close()
+		return nil, fmt.Errorf("failed to read parquet file %s: %w", fName, err)
+	}
+	return &parquetRecordReader{pf: pf, pr: pr, remaining: pr.GetNumRows()}, nil
+}
+
+func (pr *parquetRecordReader) next() error {
+	pr.batchIdx++
+	if pr.batchIdx < len(pr.batch) {
+		return nil
+	}
+	if pr.remaining <= 0 {
+		return io.EOF
+	}
+	num := int64(parquetBatchSize)
+	if num > pr.remaining {
+		num = pr.remaining
+	}
+	batch, err := pr.pr.ReadByNumber(int(num))
+	if err != nil {
+		return err
+	}
+	if len(batch) == 0 {
+		return io.EOF
+	}
+	pr.remaining -= int64(len(batch))
+	pr.batch = batch
+	pr.batchIdx = 0
+	return nil
+}
+
+func (pr *parquetRecordReader) record() (map[string]interface{}, error) {
+	root := pr.pr.SchemaHandler.GetRootInName()
+	val, ok := pr.parquetValue(reflect.ValueOf(pr.batch[pr.batchIdx]), root).(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("parquet row is not a struct: %+v", pr.batch[pr.batchIdx])
+	}
+	return val, nil
+}
+
+// converts a value read by parquet-go into json types. parquet-go names struct fields by their Go
+// friendly in-name, so field names are mapped back to the names in the file schema
+func (pr *parquetRecordReader) parquetValue(v reflect.Value, inPath string) interface{} {
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return nil
+		}
+		return pr.parquetValue(v.Elem(), inPath)
+	case reflect.Struct:
+		m := make(map[string]interface{}, v.NumField())
+		for i := 0; i < v.NumField(); i++ {
+			fieldPath := inPath + common.PAR_GO_PATH_DELIMITER + v.Type().Field(i).Name
+			m[pr.exName(fieldPath)] = pr.parquetValue(v.Field(i), fieldPath)
+		}
+		return m
+	case reflect.Slice:
+		arr := make([]interface{}, v.Len())
+		for i := 0; i < v.Len(); i++ {
+			arr[i] = pr.parquetValue(v.Index(i), inPath)
+		}
+		return arr
+	case reflect.Map:
+		m := make(map[string]interface{}, v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			m[fmt.Sprintf("%v", iter.Key().Interface())] = pr.parquetValue(iter.Value(), inPath)
+		}
+		return m
+	default:
+		return v.Interface()
+	}
+}
+
+func (pr *parquetRecordReader) exName(inPath string) string {
+	exPath, ok := pr.pr.SchemaHandler.InPathToExPath[inPath]
+	if !ok {
+		exPath = inPath
+	}
+	path := common.StrToPath(exPath)
+	return path[len(path)-1]
+}
+
+func (pr *parquetRecordReader) Close() error {
+	pr.pr.ReadStop()
+	return pr.pf.Close()
+}
@@ -0,0 +1,19 @@
+package utils
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_RowToMap(t *testing.T) {
+	m := make(map[string]interface{})
+	RowToMap([]string{"a", "b", "c", "d", "e"}, []string{"1", "2.5", "true", "abc"}, m)
+	assert.Equal(t, map[string]interface{}{"a": int64(1), "b": 2.5, "c": true, "d": "abc", "e": nil}, m)
+}
+
+func Test_decodeLogfmtLine(t *testing.T) {
+	m, err := decodeLogfmtLine([]byte(`level=info msg="hello world" latency=12 cached`))
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"level": "info", "msg": "hello world", "latency": int64(12), "cached": true}, m)
+}
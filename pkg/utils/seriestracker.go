@@ -0,0 +1,215 @@
+package utils
+
+import (
+	"fmt"
+	"math"
+	"math/bits"
+	"sync"
+)
+
+// Ways of counting unique series
+const (
+	// keeps a 64 bit hash of every series. Uses memory proportional to the number of series. Two series only count
+	// once if their hashes collide, which happens about n^2/2^65 times for n series, e.g. once in 3700 runs of 10^8 series
+	CounterExact = "exact"
+	// HyperLogLog with 2^precision registers. Standard error is about 1.04/sqrt(2^precision)
+	CounterHLL = "hll"
+)
+
+type cardinalityCounter interface {
+	add(h uint64)
+	// merge adds all values of other, which must be the same type of counter
+	merge(other cardinalityCounter)
+	count() uint64
+}
+
+type exactCounter map[uint64]struct{}
+
+func (ec exactCounter) add(h uint64) {
+	ec[h] = struct{}{}
+}
+
+func (ec exactCounter) merge(other cardinalityCounter) {
+	for h := range other.(exactCounter) {
+		ec[h] = struct{}{}
+	}
+}
+
+func (ec exactCounter) count() uint64 {
+	return uint64(len(ec))
+}
+
+type hllCounter struct {
+	precision uint8
+	registers []uint8
+}
+
+func newHLLCounter(precision uint8) *hllCounter {
+	return &hllCounter{precision: precision, registers: make([]uint8, 1<<precision)}
+}
+
+func (hc *hllCounter) add(h uint64) {
+	// fnv hashes are not well mixed in the high bits used for the register index
+	h = uint64(DeriveSeed(int64(h), 0))
+	idx := h >> (64 - hc.precision)
+	rank := uint8(bits.LeadingZeros64(h<<hc.precision|1<<(hc.precision-1))) + 1
+	if rank > hc.registers[idx] {
+		hc.registers[idx] = rank
+	}
+}
+
+func (hc *hllCounter) merge(other cardinalityCounter) {
+	for i, r := range other.(*hllCounter).registers {
+		if r > hc.registers[i] {
+			hc.registers[i] = r
+		}
+	}
+}
+
+func (hc *hllCounter) count() uint64 {
+	m := float64(len(hc.registers))
+	sum := 0.0
+	zeros := 0
+	for _, r := range hc.registers {
+		sum += 1 / float64(uint64(1)<<r)
+		if r == 0 {
+			zeros++
+		}
+	}
+	estimate := 0.7213 / (1 + 1.079/m) * m * m / sum
+	if estimate <= 2.5*m && zeros > 0 {
+		// linear counting is more accurate for small cardinalities
+		estimate = m * math.Log(m/float64(zeros))
+	}
+	return uint64(estimate + 0.5)
+}
+
+// SeriesTracker counts the unique series sent by a single generator. The counts of several trackers are combined
+// with MergeSeriesCounts, which can be called while the generators are running
+type SeriesTracker struct {
+	lock       sync.Mutex
+	newCounter func() cardinalityCounter
+
+	total  cardinalityCounter
+	active cardinalityCounter
+	// unique series of each metric name
+	perMetric map[string]cardinalityCounter
+	// unique values of each tag key
+	perTagKey map[string]cardinalityCounter
+}
+
+// SeriesCounts are the unique series sent by all generators
+type SeriesCounts struct {
+	// series sent since the previous call to MergeSeriesCounts
+	Active    uint64
+	Total     uint64
+	PerMetric map[string]uint64
+	PerTagKey map[string]uint64
+}
+
+// mode is CounterExact or CounterHLL. precision is only used by CounterHLL
+func InitSeriesTracker(mode string, precision int) (*SeriesTracker, error) {
+	var newCounter func() cardinalityCounter
+	switch mode {
+	case "", CounterExact:
+		newCounter = func() cardinalityCounter { return make(exactCounter) }
+	case CounterHLL:
+		if precision < 4 || precision > 18 {
+			return nil, fmt.Errorf("hll precision must be between 4 and 18, got %d", precision)
+		}
+		newCounter = func() cardinalityCounter { return newHLLCounter(uint8(precision)) }
+	default:
+		return nil, fmt.Errorf("unsupported series counter %s. Options=[%s,%s]", mode, CounterExact, CounterHLL)
+	}
+	return &SeriesTracker{
+		newCounter: newCounter,
+		total:      newCounter(),
+		active:     newCounter(),
+		perMetric:  make(map[string]cardinalityCounter),
+		perTagKey:  make(map[string]cardinalityCounter),
+	}, nil
+}
+
+func (st *SeriesTracker) add(mName string, tags map[string]interface{}) {
+	h := hashString(seriesKey(mName, tags))
+	st.lock.Lock()
+	defer st.lock.Unlock()
+	st.total.add(h)
+	st.active.add(h)
+	st.counterFor(st.perMetric, mName).add(h)
+	for k, v := range tags {
+		st.counterFor(st.perTagKey, k).add(hashValue(v))
+	}
+}
+
+func (st *SeriesTracker) counterFor(counters map[string]cardinalityCounter, key string) cardinalityCounter {
+	c, ok := counters[key]
+	if !ok {
+		c = st.newCounter()
+		counters[key] = c
+	}
+	return c
+}
+
+// MergeSeriesCounts combines the counts of all trackers, which must use the same mode, and resets their active series
+func MergeSeriesCounts(trackers []*SeriesTracker) SeriesCounts {
+	counts := SeriesCounts{
+		PerMetric: make(map[string]uint64),
+		PerTagKey: make(map[string]uint64),
+	}
+	if len(trackers) == 0 {
+		return counts
+	}
+	newCounter := trackers[0].newCounter
+	total := newCounter()
+	active := newCounter()
+	perMetric := make(map[string]cardinalityCounter)
+	perTagKey := make(map[string]cardinalityCounter)
+	for _, st := range trackers {
+		st.lock.Lock()
+		total.merge(st.total)
+		active.merge(st.active)
+		st.active = st.newCounter()
+		mergeCounters(perMetric, st.perMetric, newCounter)
+		mergeCounters(perTagKey, st.perTagKey, newCounter)
+		st.lock.Unlock()
+	}
+	counts.Active = active.count()
+	counts.Total = total.count()
+	for k, c := range perMetric {
+		counts.PerMetric[k] = c.count()
+	}
+	for k, c := range perTagKey {
+		counts.PerTagKey[k] = c.count()
+	}
+	return counts
+}
+
+func mergeCounters(dst, src map[string]cardinalityCounter, newCounter func() cardinalityCounter) {
+	for k, c := range src {
+		d, ok := dst[k]
+		if !ok {
+			d = newCounter()
+			dst[k] = d
+		}
+		d.merge(c)
+	}
+}
+
+// 64 bit FNV-1a of s, without allocating
+func hashString(s string) uint64 {
+	h := uint64(14695981039346656037)
+	for i := 0; i < len(s); i++ {
+		h ^= uint64(s[i])
+		h *= 1099511628211
+	}
+	return h
+}
+
+// tag values are almost always strings, which are hashed without formatting them
+func hashValue(v interface{}) uint64 {
+	if s, ok := v.(string); ok {
+		return hashString(s)
+	}
+	return hashString(fmt.Sprintf("%v", v))
+}
@@ -0,0 +1,193 @@
+package utils
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Formats of the timestamp field that TimestampRewriter can parse. Any other value is used as a go time layout
+const (
+	TsFormatEpochSeconds = "epoch_seconds"
+	TsFormatEpochMillis  = "epoch_millis"
+	TsFormatEpochNanos   = "epoch_nanos"
+	TsFormatRFC3339      = "rfc3339"
+)
+
+// units of the epoch formats
+var tsEpochUnits = map[string]time.Duration{
+	TsFormatEpochSeconds: time.Second,
+	TsFormatEpochMillis:  time.Millisecond,
+	TsFormatEpochNanos:   time.Nanosecond,
+}
+
+// Modes of TimestampRewriter
+const (
+	// shift all timestamps by the same amount so that the latest record in the input is at the current time.
+	// The input is read once more before sending to find the latest record
+	TsModeShift = "shift"
+	// set the timestamp of each record to the time it is sent
+	TsModeNow = "now"
+)
+
+// Rewrites a timestamp field of the records read by FileReader.
+// The same rewriter should be shared by all readers so that they use the same shift
+type TimestampRewriter struct {
+	field  string
+	format string
+	mode   string
+
+	once    sync.Once
+	initErr error
+	shift   time.Duration
+}
+
+func InitTimestampRewriter(field, format, mode string) (*TimestampRewriter, error) {
+	if field == "" {
+		return nil, fmt.Errorf("timestamp field must be specified")
+	}
+	if format == "" {
+		format = TsFormatEpochMillis
+	}
+	if mode != TsModeShift && mode != TsModeNow {
+		return nil, fmt.Errorf("unsupported timestamp rewrite mode %s. Options=[%s,%s]", mode, TsModeShift, TsModeNow)
+	}
+	return &TimestampRewriter{
+		field:  field,
+		format: format,
+		mode:   mode,
+	}, nil
+}
+
+// prepare computes the shift needed to make the latest timestamp in files the current time.
+// Only the first call does any work, so all readers sharing the rewriter will get the same shift
+func (tr *TimestampRewriter) prepare(files []string, format string) error {
+	tr.once.Do(func() {
+		if tr.mode != TsModeShift {
+			return
+		}
+		var maxTs time.Time
+		for _, fName := range files {
+			latest, err := tr.latestInFile(fName, format)
+			if err != nil {
+				tr.initErr = err
+				return
+			}
+			if latest.After(maxTs) {
+				maxTs = latest
+			}
+		}
+		if maxTs.IsZero() {
+			tr.initErr = fmt.Errorf("timestamp field %s not found in %+v", tr.field, files)
+			return
+		}
+		tr.shift = time.Since(maxTs).Truncate(time.Millisecond)
+		log.Infof("Latest %s in input is %+v. Shifting all timestamps by %+v", tr.field, maxTs, tr.shift)
+	})
+	return tr.initErr
+}
+
+func (tr *TimestampRewriter) latestInFile(fName, format string) (time.Time, error) {
+	var latest time.Time
+	rr, err := openRecordReader(format, fName)
+	if err != nil {
+		return latest, err
+	}
+	defer rr.Close()
+	for {
+		err := rr.next()
+		if err == io.EOF {
+			return latest, nil
+		} else if err != nil {
+			return latest, err
+		}
+		m, err := rr.record()
+		if err != nil {
+			return latest, err
+		}
+		rawTs, ok := m[tr.field]
+		if !ok {
+			continue
+		}
+		ts, err := tr.parse(rawTs)
+		if err != nil {
+			return latest, err
+		}
+		if ts.After(latest) {
+			latest = ts
+		}
+	}
+}
+
+// rewrite updates the timestamp field of m in place. Records without the field are left as is
+func (tr *TimestampRewriter) rewrite(m map[string]interface{}) error {
+	rawTs, ok := m[tr.field]
+	if !ok {
+		return nil
+	}
+	ts, err := tr.parse(rawTs)
+	if err != nil {
+		return err
+	}
+	switch tr.mode {
+	case TsModeShift:
+		ts = ts.Add(tr.shift)
+	case TsModeNow:
+		ts = time.Now().In(ts.Location())
+	}
+	m[tr.field] = tr.formatTs(ts, rawTs)
+	return nil
+}
+
+func (tr *TimestampRewriter) parse(rawTs interface{}) (time.Time, error) {
+	if unit, ok := tsEpochUnits[tr.format]; ok {
+		switch v := rawTs.(type) {
+		case float64:
+			return time.Unix(0, int64(v)*int64(unit)), nil
+		case int64:
+			return time.Unix(0, v*int64(unit)), nil
+		case int:
+			return time.Unix(0, int64(v)*int64(unit)), nil
+		case string:
+			epoch, err := strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				return time.Time{}, fmt.Errorf("failed to parse %s=%v as %s: %w", tr.field, rawTs, tr.format, err)
+			}
+			return time.Unix(0, epoch*int64(unit)), nil
+		}
+	} else {
+		layout := tr.format
+		if layout == TsFormatRFC3339 {
+			layout = time.RFC3339Nano
+		}
+		if v, ok := rawTs.(string); ok {
+			ts, err := time.Parse(layout, v)
+			if err != nil {
+				return time.Time{}, fmt.Errorf("failed to parse %s=%v with layout %s: %w", tr.field, rawTs, layout, err)
+			}
+			return ts, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("unsupported value %+v for timestamp field %s with format %s", rawTs, tr.field, tr.format)
+}
+
+// formats ts the same way as the original value
+func (tr *TimestampRewriter) formatTs(ts time.Time, orig interface{}) interface{} {
+	if unit, ok := tsEpochUnits[tr.format]; ok {
+		epoch := ts.UnixNano() / int64(unit)
+		if _, ok := orig.(string); ok {
+			return strconv.FormatInt(epoch, 10)
+		}
+		return epoch
+	}
+	switch tr.format {
+	case TsFormatRFC3339:
+		return ts.Format(time.RFC3339Nano)
+	default:
+		return ts.Format(tr.format)
+	}
+}
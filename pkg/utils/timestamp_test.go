@@ -0,0 +1,132 @@
+package utils
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_timestampParseAndFormat(t *testing.T) {
+	cases := []struct {
+		format string
+		raw    interface{}
+		ts     time.Time
+		// formatted ts, if different from raw
+		formatted interface{}
+	}{
+		{format: TsFormatEpochSeconds, raw: int64(1_700_000_000), ts: time.Unix(1_700_000_000, 0)},
+		{format: TsFormatEpochSeconds, raw: "1700000000", ts: time.Unix(1_700_000_000, 0)},
+		// json numbers are decoded as float64
+		{format: TsFormatEpochSeconds, raw: 1_700_000_000.0, ts: time.Unix(1_700_000_000, 0), formatted: int64(1_700_000_000)},
+		{format: TsFormatEpochMillis, raw: int64(1_700_000_000_123), ts: time.UnixMilli(1_700_000_000_123)},
+		{format: TsFormatEpochMillis, raw: "1700000000123", ts: time.UnixMilli(1_700_000_000_123)},
+		{format: TsFormatEpochMillis, raw: 1_700_000_000_123, ts: time.UnixMilli(1_700_000_000_123), formatted: int64(1_700_000_000_123)},
+		{format: TsFormatEpochNanos, raw: int64(1_700_000_000_123_456_789), ts: time.Unix(0, 1_700_000_000_123_456_789)},
+		{format: TsFormatEpochNanos, raw: "1700000000123456789", ts: time.Unix(0, 1_700_000_000_123_456_789)},
+		{format: TsFormatRFC3339, raw: "2023-11-14T22:13:20.123Z", ts: time.UnixMilli(1_700_000_000_123)},
+		// the offset is kept
+		{format: TsFormatRFC3339, raw: "2023-11-14T23:13:20+01:00", ts: time.Unix(1_700_000_000, 0)},
+		{format: "2006-01-02 15:04:05", raw: "2023-11-14 22:13:20", ts: time.Unix(1_700_000_000, 0)},
+	}
+	for _, tc := range cases {
+		tr, err := InitTimestampRewriter("timestamp", tc.format, TsModeShift)
+		assert.NoError(t, err, tc.raw)
+		ts, err := tr.parse(tc.raw)
+		assert.NoError(t, err, tc.raw)
+		assert.True(t, tc.ts.Equal(ts), "%v: %v != %v", tc.raw, tc.ts, ts)
+		expected := tc.formatted
+		if expected == nil {
+			expected = tc.raw
+		}
+		assert.Equal(t, expected, tr.formatTs(ts, tc.raw), tc.raw)
+	}
+
+	errCases := []struct {
+		format string
+		raw    interface{}
+	}{
+		{TsFormatEpochMillis, "yesterday"},
+		{TsFormatEpochSeconds, true},
+		{TsFormatRFC3339, 1_700_000_000.0},
+		{TsFormatRFC3339, "2023-11-14"},
+		{"2006-01-02", "14.11.2023"},
+	}
+	for _, tc := range errCases {
+		tr, err := InitTimestampRewriter("timestamp", tc.format, TsModeShift)
+		assert.NoError(t, err, tc.raw)
+		_, err = tr.parse(tc.raw)
+		assert.Error(t, err, tc.raw)
+	}
+}
+
+func Test_InitTimestampRewriter(t *testing.T) {
+	tr, err := InitTimestampRewriter("timestamp", "", TsModeNow)
+	assert.NoError(t, err)
+	assert.Equal(t, TsFormatEpochMillis, tr.format)
+	_, err = InitTimestampRewriter("", TsFormatEpochMillis, TsModeNow)
+	assert.Error(t, err)
+	_, err = InitTimestampRewriter("timestamp", TsFormatEpochMillis, "later")
+	assert.Error(t, err)
+}
+
+func Test_timestampRewriterShift(t *testing.T) {
+	dir := t.TempDir()
+	files := []string{filepath.Join(dir, "a.json"), filepath.Join(dir, "b.json")}
+	// the latest timestamp is in the middle of the second file
+	assert.NoError(t, ioutil.WriteFile(files[0], []byte("{\"timestamp\":1000}\n{\"msg\":\"no timestamp\"}\n{\"timestamp\":3000}\n"), 0644))
+	assert.NoError(t, ioutil.WriteFile(files[1], []byte("{\"timestamp\":2000}\n{\"timestamp\":5000}\n{\"timestamp\":4000}\n"), 0644))
+
+	tr, err := InitTimestampRewriter("timestamp", TsFormatEpochMillis, TsModeShift)
+	assert.NoError(t, err)
+	before := time.Now()
+	assert.NoError(t, tr.prepare(files, FormatJSON))
+	after := time.Now()
+	// later calls keep the shift of the first one
+	shift := tr.shift
+	assert.NoError(t, tr.prepare(files[:1], FormatJSON))
+	assert.Equal(t, shift, tr.shift)
+
+	latest := map[string]interface{}{"timestamp": 5000.0}
+	assert.NoError(t, tr.rewrite(latest))
+	assert.GreaterOrEqual(t, latest["timestamp"], before.UnixMilli()-1)
+	assert.LessOrEqual(t, latest["timestamp"], after.UnixMilli())
+
+	// the spacing between records is kept
+	first := map[string]interface{}{"timestamp": "1000"}
+	assert.NoError(t, tr.rewrite(first))
+	firstMs, err := strconv.ParseInt(first["timestamp"].(string), 10, 64)
+	assert.NoError(t, err)
+	assert.Equal(t, latest["timestamp"].(int64)-4000, firstMs)
+
+	missing := map[string]interface{}{"msg": "no timestamp"}
+	assert.NoError(t, tr.rewrite(missing))
+	assert.Equal(t, map[string]interface{}{"msg": "no timestamp"}, missing)
+
+	assert.Error(t, tr.rewrite(map[string]interface{}{"timestamp": "yesterday"}))
+
+	noField, err := InitTimestampRewriter("ts", TsFormatEpochMillis, TsModeShift)
+	assert.NoError(t, err)
+	assert.Error(t, noField.prepare(files, FormatJSON))
+}
+
+func Test_timestampRewriterNow(t *testing.T) {
+	tr, err := InitTimestampRewriter("timestamp", TsFormatRFC3339, TsModeNow)
+	assert.NoError(t, err)
+	// now mode does not read the files
+	assert.NoError(t, tr.prepare([]string{filepath.Join(t.TempDir(), "missing.json")}, FormatJSON))
+
+	m := map[string]interface{}{"timestamp": "2023-11-14T23:13:20+01:00"}
+	before := time.Now()
+	assert.NoError(t, tr.rewrite(m))
+	after := time.Now()
+	ts, err := time.Parse(time.RFC3339Nano, m["timestamp"].(string))
+	assert.NoError(t, err)
+	assert.False(t, ts.Before(before), ts)
+	assert.False(t, ts.After(after), ts)
+	_, offset := ts.Zone()
+	assert.Equal(t, 3600, offset)
+}